@@ -0,0 +1,363 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// How often idle alert groups are forgotten.
+const alertSweepInterval = time.Minute
+
+// alertFields extract the values alert rules match and group on. Fields
+// absent from an event have no values, and match as an empty string.
+var alertFields = map[string]func(e *Event) []string{
+	config.AlertFieldType: func(e *Event) []string {
+		return []string{e.Type}
+	},
+	config.AlertFieldUser: func(e *Event) []string {
+		switch {
+		case e.Stats != nil:
+			return []string{e.Stats.User}
+		case e.Session != nil:
+			return []string{e.Session.User}
+		}
+		return nil
+	},
+	config.AlertFieldTarget: func(e *Event) []string {
+		switch {
+		case e.Stats != nil:
+			return []string{e.Stats.Target}
+		case e.Session != nil:
+			return []string{e.Session.Target}
+		}
+		return nil
+	},
+	config.AlertFieldDatabase: func(e *Event) []string {
+		if e.Session == nil {
+			return nil
+		}
+		return []string{e.Session.Database}
+	},
+	config.AlertFieldClient: func(e *Event) []string {
+		if e.Session == nil {
+			return nil
+		}
+		// Without the port, which changes with every connection
+		host, _, err := net.SplitHostPort(e.Session.Client)
+		if err != nil {
+			host = e.Session.Client
+		}
+		return []string{host}
+	},
+	config.AlertFieldSession: func(e *Event) []string {
+		if e.Session == nil {
+			return nil
+		}
+		return []string{e.Session.ID}
+	},
+	config.AlertFieldBreakGlass: func(e *Event) []string {
+		if e.Session == nil {
+			return nil
+		}
+		return []string{strconv.FormatBool(e.Session.BreakGlass)}
+	},
+	config.AlertFieldMessage: func(e *Event) []string {
+		if e.Statement == nil {
+			return nil
+		}
+		return []string{e.Statement.Message}
+	},
+	config.AlertFieldClass: func(e *Event) []string {
+		if e.Statement == nil {
+			return nil
+		}
+		return e.Statement.Classes
+	},
+	config.AlertFieldRelation: func(e *Event) []string {
+		if e.Statement == nil {
+			return nil
+		}
+		var names []string
+		for _, r := range e.Statement.Relations {
+			names = append(names, r.QualifiedName())
+		}
+		return names
+	},
+	config.AlertFieldQuery: func(e *Event) []string {
+		switch {
+		case e.Statement != nil:
+			return []string{e.Statement.Query}
+		case e.Stats != nil:
+			return []string{e.Stats.Query}
+		}
+		return nil
+	},
+	config.AlertFieldDecision: outcomeField(func(o *Outcome) string { return o.Decision }),
+	config.AlertFieldReason:   outcomeField(func(o *Outcome) string { return o.Reason }),
+	config.AlertFieldSeverity: outcomeField(func(o *Outcome) string { return o.Severity }),
+	config.AlertFieldSQLState: outcomeField(func(o *Outcome) string { return o.SQLState }),
+	config.AlertFieldError:    outcomeField(func(o *Outcome) string { return o.Error }),
+}
+
+func outcomeField(get func(o *Outcome) string) func(e *Event) []string {
+	return func(e *Event) []string {
+		if e.Outcome == nil {
+			return nil
+		}
+		return []string{get(e.Outcome)}
+	}
+}
+
+// alertGroup tracks the events matching a rule for one combination of its
+// groupBy fields.
+type alertGroup struct {
+	values map[string]string
+	// Times of the matches within the window, oldest first
+	times []time.Time
+	// Alerts are held back until then
+	suppressUntil time.Time
+	suppressed    int
+}
+
+type alertRule struct {
+	config.AlertRule
+	sinks  []Sink
+	groups map[string]*alertGroup
+}
+
+func (r *alertRule) matches(e *Event) bool {
+	for field, re := range r.Match {
+		values := alertFields[field](e)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+		matched := false
+		for _, v := range values {
+			if re.MatchString(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *alertRule) group(e *Event) *alertGroup {
+	var key strings.Builder
+	values := map[string]string{}
+	for _, field := range r.GroupBy {
+		var v string
+		if vs := alertFields[field](e); len(vs) > 0 {
+			v = vs[0]
+		}
+		values[field] = v
+		key.WriteString(v)
+		key.WriteByte(0)
+	}
+
+	g, ok := r.groups[key.String()]
+	if !ok {
+		g = &alertGroup{}
+		if len(values) > 0 {
+			g.values = values
+		}
+		r.groups[key.String()] = g
+	}
+	return g
+}
+
+// AlertEngine evaluates alert rules on audit events and sends alert events
+// to the rules' destinations. It is added to the auditor as a sink, so it
+// sees events exactly as the other sinks do, after redaction. Alerts are
+// rate-limited across every rule.
+type AlertEngine struct {
+	mtx   sync.Mutex
+	rules []*alertRule
+	sinks []Sink
+
+	// Token bucket refilled at rateLimit tokens per minute
+	rateLimit float64
+	tokens    float64
+	refilled  time.Time
+	dropped   int
+
+	swept time.Time
+}
+
+// NewAlertEngine opens the destinations of the alert rules.
+func NewAlertEngine(c config.AuditAlerts) (*AlertEngine, error) {
+	now := time.Now()
+	a := &AlertEngine{
+		rateLimit: float64(c.RateLimit),
+		tokens:    float64(c.RateLimit),
+		refilled:  now,
+		swept:     now,
+	}
+
+	byName := map[string]Sink{}
+	for _, d := range c.Destinations {
+		s, err := openSink(d.Sink)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("Error in alert destination %s: %w", d.Name, err)
+		}
+		a.sinks = append(a.sinks, s)
+		byName[d.Name] = s
+	}
+
+	for _, rc := range c.Rules {
+		r := &alertRule{AlertRule: rc, groups: map[string]*alertGroup{}}
+		if len(rc.Destinations) == 0 {
+			r.sinks = a.sinks
+		}
+		for _, name := range rc.Destinations {
+			r.sinks = append(r.sinks, byName[name])
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+// Write counts e against every rule it matches, firing those that reach
+// their threshold. Failing to deliver an alert is logged rather than
+// returned, so that it never counts as failing to audit e.
+func (a *AlertEngine) Write(e *Event) error {
+	if e.Type == EventAlert {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := time.Now()
+	for _, r := range a.rules {
+		if !r.matches(e) {
+			continue
+		}
+
+		g := r.group(e)
+		cutoff := now.Add(-r.Window)
+		i := 0
+		for i < len(g.times) && !g.times[i].After(cutoff) {
+			i++
+		}
+		g.times = append(g.times[i:], now)
+		if len(g.times) < r.Count {
+			continue
+		}
+		count, first := len(g.times), g.times[0]
+		g.times = g.times[:0]
+
+		if now.Before(g.suppressUntil) {
+			g.suppressed++
+			continue
+		}
+		g.suppressUntil = now.Add(r.Suppress)
+		alert := &Alert{
+			Rule:        r.Name,
+			Description: r.Description,
+			Severity:    r.Severity,
+			Count:       count,
+			WindowMs:    r.Window.Milliseconds(),
+			First:       first.UTC(),
+			Group:       g.values,
+			Suppressed:  g.suppressed,
+		}
+		g.suppressed = 0
+
+		if !a.allow(now) {
+			a.dropped++
+			continue
+		}
+		alert.Dropped = a.dropped
+		a.dropped = 0
+		a.send(r, e, alert, now)
+	}
+
+	if now.Sub(a.swept) >= alertSweepInterval {
+		a.sweep(now)
+	}
+	return nil
+}
+
+// allow takes a token from the rate limit, if there is one left.
+func (a *AlertEngine) allow(now time.Time) bool {
+	a.tokens += now.Sub(a.refilled).Minutes() * a.rateLimit
+	if a.tokens > a.rateLimit {
+		a.tokens = a.rateLimit
+	}
+	a.refilled = now
+	if a.tokens < 1 {
+		return false
+	}
+	a.tokens--
+	return true
+}
+
+// send delivers an alert fired by e to the rule's destinations.
+func (a *AlertEngine) send(r *alertRule, e *Event, alert *Alert, now time.Time) {
+	log.Infof("Alert %s fired after %d event(s)", r.Name, alert.Count)
+
+	ae := &Event{
+		Version:   SchemaVersion,
+		Time:      now.UTC(),
+		Type:      EventAlert,
+		Alert:     alert,
+		Statement: e.Statement,
+		Limit:     e.Limit,
+	}
+	// The session and outcome may still change after e was audited
+	if e.Session != nil {
+		s := *e.Session
+		ae.Session = &s
+	}
+	if e.Outcome != nil {
+		o := *e.Outcome
+		ae.Outcome = &o
+	}
+
+	for _, s := range r.sinks {
+		if err := s.Write(ae); err != nil {
+			log.Errorf("Error sending alert %s: %v", r.Name, err)
+		}
+	}
+}
+
+// sweep forgets groups with no match left in their window and no alert
+// held back.
+func (a *AlertEngine) sweep(now time.Time) {
+	for _, r := range a.rules {
+		cutoff := now.Add(-r.Window)
+		for key, g := range r.groups {
+			idle := len(g.times) == 0 || !g.times[len(g.times)-1].After(cutoff)
+			if idle && !now.Before(g.suppressUntil) {
+				delete(r.groups, key)
+			}
+		}
+	}
+	a.swept = now
+}
+
+// Close closes every destination, delivering the alerts they still hold.
+func (a *AlertEngine) Close() error {
+	var errs []string
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error closing alert destinations: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
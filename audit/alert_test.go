@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+func TestBreakGlassAlertWebhook(t *testing.T) {
+	received := make(chan []*Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var events []*Event
+		if err := json.Unmarshal(body, &events); err != nil {
+			t.Errorf("unexpected body %q: %v", body, err)
+		}
+		received <- events
+	}))
+	defer srv.Close()
+
+	c, err := config.FromFile(&file.Config{
+		Server:     file.ServerConfig{AllowUnencrypted: true},
+		Client:     file.ClientConfig{AllowUnencrypted: true},
+		BreakGlass: file.BreakGlassConfig{Enabled: true, AlertWebhook: srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAlertEngine(c.Audit.Alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	session := &Session{ID: "s", User: "alice", BreakGlass: true, BreakGlassReason: "INC-1234 primary is down"}
+	a.Write(&Event{Type: EventSessionStartup, Session: &Session{ID: "s", User: "alice"}})
+	a.Write(&Event{Type: EventSessionBreakGlass, Session: session,
+		Outcome: &Outcome{Decision: DecisionAllowed, Reason: "User not allowed"}})
+	a.Write(&Event{Type: EventSessionPolicy, Session: session, Outcome: &Outcome{Decision: DecisionAllowed}})
+
+	select {
+	case events := <-received:
+		if len(events) != 1 {
+			t.Fatalf("%d events posted, want the one alert", len(events))
+		}
+		e := events[0]
+		if e.Type != EventAlert || e.Alert.Rule != "break-glass" || e.Alert.Severity != config.AlertSeverityCritical {
+			t.Errorf("posted %+v, want a critical break-glass alert", e.Alert)
+		}
+		if e.Session == nil || e.Session.BreakGlassReason != session.BreakGlassReason || e.Outcome.Reason != "User not allowed" {
+			t.Errorf("alert carries session %+v and outcome %+v", e.Session, e.Outcome)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert posted")
+	}
+}
+
+func TestAlertBreakGlassField(t *testing.T) {
+	field := alertFields[config.AlertFieldBreakGlass]
+	tests := []struct {
+		e    *Event
+		want []string
+	}{
+		{&Event{Type: EventStatement, Session: &Session{BreakGlass: true}}, []string{"true"}},
+		{&Event{Type: EventStatement, Session: &Session{}}, []string{"false"}},
+		{&Event{Type: EventStatsSummary, Stats: &StatementStats{}}, nil},
+	}
+	for _, tt := range tests {
+		got := field(tt.e)
+		if len(got) != len(tt.want) || len(got) > 0 && got[0] != tt.want[0] {
+			t.Errorf("%s event: got %q, want %q", tt.e.Type, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package audit delivers audit events, kept apart from mammoth's
+// operational logging so that changing the log level or format never
+// drops or reformats audit data.
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunopadz/mammoth/config"
+)
+
+// Sink receives audit events.
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// Reopener is implemented by sinks that can start over with a new file,
+// e.g. after it was moved aside by an external tool.
+type Reopener interface {
+	Reopen() error
+}
+
+// Checker is implemented by sinks that can tell whether they are able to
+// deliver events right now, e.g. whether their server is reachable and
+// their buffer has room.
+type Checker interface {
+	Check() error
+}
+
+// Auditor fans events out to every configured sink. With chaining enabled,
+// events are linked into a hash chain in the order they reach the sinks.
+type Auditor struct {
+	mtx      sync.Mutex
+	sinks    []Sink
+	redactor *Redactor
+	chain    *chain
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	// The ongoing delivery outage, if any, see Check
+	outageMtx sync.Mutex
+	outage    *Outage
+}
+
+func New(sinks ...Sink) *Auditor {
+	return &Auditor{sinks: sinks, stop: make(chan struct{})}
+}
+
+// Open creates the sinks described by the configuration. Without any, audit
+// events are written to stdout as JSON.
+func Open(c config.Audit) (*Auditor, error) {
+	if len(c.Sinks) == 0 {
+		c.Sinks = []config.AuditSink{{Type: "stdout"}}
+	}
+
+	a := New()
+	a.redactor = NewRedactor(c.Redaction)
+	for i, sc := range c.Sinks {
+		s, err := openSink(sc)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("Error in audit sink %d (%s): %w", i, sc.Type, err)
+		}
+		a.sinks = append(a.sinks, s)
+	}
+	if len(c.Alerts.Rules) > 0 {
+		e, err := NewAlertEngine(c.Alerts)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.sinks = append(a.sinks, e)
+	}
+
+	if c.Chain.Enabled {
+		if err := a.startChain(c.Chain); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	a.wg.Add(1)
+	go a.monitor()
+	return a, nil
+}
+
+func openSink(c config.AuditSink) (Sink, error) {
+	f, err := NewFormatter(c.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "stdout":
+		return NewStdoutSink(f), nil
+	case "file":
+		opts := FileOptions{
+			SegmentInterval: c.SegmentInterval,
+			MaxSize:         c.MaxSize,
+			Compress:        c.Compress,
+			Retention:       c.Retention,
+		}
+		if c.SigningKey != "" {
+			if opts.SigningKey, err = LoadPrivateKey(c.SigningKey); err != nil {
+				return nil, fmt.Errorf("Error loading signing key: %w", err)
+			}
+		}
+		return NewFileSink(c.Path, f, opts)
+	case "syslog":
+		return NewSyslogSink(f, SyslogOptions{
+			Network:    c.Network,
+			Address:    c.Address,
+			TLSConfig:  c.TLS,
+			Facility:   c.Facility,
+			AppName:    c.AppName,
+			BufferSize: c.BufferSize,
+		}), nil
+	case "webhook":
+		return NewWebhookSink(f, WebhookOptions{
+			URL:           c.URL,
+			Headers:       c.Headers,
+			TLSConfig:     c.TLS,
+			Secret:        c.Secret,
+			BatchSize:     c.BatchSize,
+			FlushInterval: c.FlushInterval,
+			MaxRetries:    c.MaxRetries,
+			Timeout:       c.Timeout,
+			DeadLetter:    c.DeadLetter,
+			BufferSize:    c.BufferSize,
+		}), nil
+	case "kafka":
+		opts := KafkaOptions{
+			Brokers:       c.Brokers,
+			Topic:         c.Topic,
+			ClientID:      c.ClientID,
+			SASLMechanism: c.SASLMechanism,
+			SASLUsername:  c.SASLUsername,
+			SASLPassword:  c.SASLPassword,
+			Compress:      c.Compress,
+			BufferSize:    c.BufferSize,
+		}
+		if c.Network == "tls" {
+			opts.TLSConfig = c.TLS
+		}
+		return NewKafkaSink(f, opts)
+	}
+	return nil, fmt.Errorf("Unknown sink type: %s", c.Type)
+}
+
+// startChain writes the first checkpoint of a new chain, and keeps writing
+// periodic ones until the auditor is closed.
+func (a *Auditor) startChain(c config.AuditChain) error {
+	records := c.CheckpointRecords
+	if records <= 0 {
+		records = defaultCheckpointRecords
+	}
+	interval := c.CheckpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	a.mtx.Lock()
+	a.chain = newChain(records)
+	err := a.write(a.chain.checkpoint(CheckpointStart))
+	a.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-a.stop:
+				return
+			case <-t.C:
+			}
+			a.mtx.Lock()
+			if a.chain.sinceCheckpoint > 0 {
+				a.write(a.chain.checkpoint(CheckpointPeriodic))
+			}
+			a.mtx.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Emit writes e to every sink. All sinks are tried even if one fails; the
+// errors are returned together.
+func (a *Auditor) Emit(e *Event) error {
+	if e.Version == 0 {
+		e.Version = SchemaVersion
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.redactor != nil {
+		a.redactor.Redact(e)
+	}
+	err := a.write(e)
+	if a.chain != nil && a.chain.due() {
+		if cpErr := a.write(a.chain.checkpoint(CheckpointPeriodic)); err == nil {
+			err = cpErr
+		}
+	}
+	return err
+}
+
+// write links e into the chain, if any, and hands it to the sinks. It must
+// be called with mtx held.
+func (a *Auditor) write(e *Event) error {
+	if a.chain != nil {
+		if err := a.chain.link(e); err != nil {
+			return fmt.Errorf("Error chaining audit event: %w", err)
+		}
+	}
+
+	var errs []string
+	for _, s := range a.sinks {
+		if err := s.Write(e); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error writing audit event: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Reopen reopens every sink that supports it.
+func (a *Auditor) Reopen() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var errs []string
+	for _, s := range a.sinks {
+		if r, ok := s.(Reopener); ok {
+			if err := r.Reopen(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error reopening audit sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close ends the chain with a stop checkpoint and closes every sink.
+func (a *Auditor) Close() error {
+	close(a.stop)
+	a.wg.Wait()
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	var errs []string
+	if a.chain != nil {
+		if err := a.write(a.chain.checkpoint(CheckpointStop)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, s := range a.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error closing audit sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Checkpoint reasons.
+const (
+	CheckpointStart    = "start"
+	CheckpointPeriodic = "periodic"
+	CheckpointStop     = "stop"
+)
+
+// Defaults for how often checkpoints are written.
+const (
+	defaultCheckpointInterval = 5 * time.Minute
+	defaultCheckpointRecords  = 1000
+)
+
+// Checkpoint is written when a chain starts, when it stops, and
+// periodically in between. A chain that doesn't end with a stop checkpoint
+// was cut short, and a periodic checkpoint lets a log that starts mid-chain
+// (e.g. after rotation) be verified from that point on.
+type Checkpoint struct {
+	// Identifies the chain, which lasts as long as the mammoth process
+	Chain   string    `json:"chain"`
+	Reason  string    `json:"reason"`
+	Started time.Time `json:"started"`
+}
+
+// chain links every record to the one before it. It is not safe for
+// concurrent use.
+type chain struct {
+	id       string
+	started  time.Time
+	seq      uint64
+	prevHash string
+
+	checkpointRecords int
+	sinceCheckpoint   int
+}
+
+func newChain(checkpointRecords int) *chain {
+	return &chain{
+		id:                NewSessionID(),
+		started:           time.Now().UTC(),
+		checkpointRecords: checkpointRecords,
+	}
+}
+
+// link numbers e and sets its hash, which covers the previous hash too.
+func (c *chain) link(e *Event) error {
+	e.Seq = c.seq + 1
+	e.PrevHash = c.prevHash
+	e.Hash = ""
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hashRecord(body)
+
+	c.seq = e.Seq
+	c.prevHash = e.Hash
+	c.sinceCheckpoint++
+	return nil
+}
+
+func (c *chain) checkpoint(reason string) *Event {
+	c.sinceCheckpoint = -1
+	return &Event{
+		Version: SchemaVersion,
+		Time:    time.Now().UTC(),
+		Type:    EventCheckpoint,
+		Checkpoint: &Checkpoint{
+			Chain:   c.id,
+			Reason:  reason,
+			Started: c.started,
+		},
+	}
+}
+
+// due reports whether enough records were written to warrant a checkpoint.
+func (c *chain) due() bool {
+	return c.sinceCheckpoint >= c.checkpointRecords
+}
+
+func hashRecord(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+var hashPrefix = []byte(`,"hash":"`)
+
+// splitRecord separates a chained JSON record into the bytes its hash was
+// computed over and the hash itself.
+func splitRecord(line []byte) (body []byte, hash string, ok bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	i := bytes.LastIndex(line, hashPrefix)
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+	hash = string(line[i+len(hashPrefix) : len(line)-2])
+
+	body = make([]byte, 0, i+1)
+	body = append(body, line[:i]...)
+	body = append(body, '}')
+	return body, hash, true
+}
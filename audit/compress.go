@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of rotated segments.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var compressExts = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// trimCompressExt returns path without the extension a compressed segment
+// was given.
+func trimCompressExt(path string) string {
+	for _, ext := range compressExts {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext)
+		}
+	}
+	return path
+}
+
+// OpenFile opens an audit file for reading, transparently decompressing
+// gzip and zstd files.
+func OpenFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Error decompressing %s: %w", path, err)
+	}
+	return r, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+func decompress(f *os.File) (io.ReadCloser, error) {
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, func() error {
+			zr.Close()
+			return f.Close()
+		}}, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{zr, func() error {
+			zr.Close()
+			return f.Close()
+		}}, nil
+	}
+	return readCloser{br, f.Close}, nil
+}
+
+// compressFile replaces the file at path with a compressed copy, and
+// returns the copy's path.
+func compressFile(path, method string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	dst := path + compressExts[method]
+	out, err := os.OpenFile(dst+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	var zw io.WriteCloser
+	if method == CompressZstd {
+		zw, err = zstd.NewWriter(out)
+	} else {
+		zw = gzip.NewWriter(out)
+	}
+	if err == nil {
+		_, err = io.Copy(zw, in)
+		if closeErr := zw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst+".tmp", dst)
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return "", err
+	}
+	return dst, os.Remove(path)
+}
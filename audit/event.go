@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"time"
+)
+
+// SchemaVersion is the version of the event schema. It is bumped whenever
+// a field is removed or changes meaning; new optional fields don't bump it.
+const SchemaVersion = 1
+
+// Event types.
+const (
+	// A message sent by the client, such as a query
+	EventStatement = "statement"
+	// The backend answered a statement mammoth let through with an error
+	EventStatementError = "statement.error"
+	// A row limit or data volume quota was exceeded
+	EventLimit = "limit"
+
+	// Session lifecycle, in the order they happen
+	EventSessionAccepted = "session.accepted"
+	EventSessionTLS      = "session.tls"
+	EventSessionStartup  = "session.startup"
+	// Break-glass access let the session through, see Session.BreakGlass
+	EventSessionBreakGlass = "session.breakglass"
+	EventSessionPolicy     = "session.policy"
+	EventSessionBackend    = "session.backend"
+	EventSessionAuth       = "session.auth"
+	EventSessionClosed     = "session.closed"
+
+	// Anchors the hash chain, see Checkpoint
+	EventCheckpoint = "audit.checkpoint"
+
+	// Statement statistics of the past summary interval, see
+	// StatementStats
+	EventStatsSummary = "stats.summary"
+
+	// An alert rule fired, see Alert
+	EventAlert = "alert"
+
+	// Events could not be delivered to every sink, and again could, see
+	// Outage
+	EventAuditUnavailable = "audit.unavailable"
+	EventAuditRecovered   = "audit.recovered"
+)
+
+// Outcome decisions.
+const (
+	DecisionAllowed   = "allowed"
+	DecisionRejected  = "rejected"
+	DecisionConfirmed = "confirmed"
+)
+
+// Event is a single audit record.
+type Event struct {
+	Version    int             `json:"version"`
+	Time       time.Time       `json:"time"`
+	Type       string          `json:"type"`
+	Session    *Session        `json:"session,omitempty"`
+	Statement  *Statement      `json:"statement,omitempty"`
+	Limit      *Limit          `json:"limit,omitempty"`
+	Connection *Connection     `json:"connection,omitempty"`
+	Outcome    *Outcome        `json:"outcome,omitempty"`
+	Checkpoint *Checkpoint     `json:"checkpoint,omitempty"`
+	Stats      *StatementStats `json:"stats,omitempty"`
+	Alert      *Alert          `json:"alert,omitempty"`
+	Outage     *Outage         `json:"outage,omitempty"`
+
+	// Hash chain, set by the auditor when chaining is enabled. Hash must
+	// remain the last field: it is computed over the record without it.
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Session identifies who is connected where.
+type Session struct {
+	// Unique for every connection accepted by mammoth
+	ID               string `json:"id"`
+	Client           string `json:"client"`
+	User             string `json:"user,omitempty"`
+	Server           string `json:"server,omitempty"`
+	Database         string `json:"database,omitempty"`
+	Target           string `json:"target,omitempty"`
+	BreakGlass       bool   `json:"breakGlass,omitempty"`
+	BreakGlassReason string `json:"breakGlassReason,omitempty"`
+}
+
+// Statement describes a message sent by the client. Which fields are set
+// depends on the protocol message it arrived in.
+type Statement struct {
+	// Protocol message, e.g. SimpleQuery, Parse, Bind or Execute
+	Message           string `json:"message"`
+	Query             string `json:"query,omitempty"`
+	PreparedStatement string `json:"preparedStatement,omitempty"`
+	Portal            string `json:"portal,omitempty"`
+	Args              []Arg  `json:"args,omitempty"`
+	FunctionOID       int32  `json:"functionOid,omitempty"`
+	// What a Close or Describe refers to: "prepared" or "portal"
+	Object  string `json:"object,omitempty"`
+	MaxRows int32  `json:"maxRows,omitempty"`
+	// Hex-encoded CopyData contents
+	Data         string `json:"data,omitempty"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+	// Type code and length of messages mammoth doesn't know
+	Code   int   `json:"code,omitempty"`
+	Length int32 `json:"length,omitempty"`
+	// Classes of the statements the message runs or refers to, e.g. READ
+	// or DDL
+	Classes []string `json:"classes,omitempty"`
+	// Relations the statements reference, for Parse, SimpleQuery and
+	// Execute messages
+	Relations []Relation `json:"relations,omitempty"`
+	// Set when redaction is configured
+	Redaction *Redaction `json:"redaction,omitempty"`
+}
+
+// Arg is a parameter bound to a prepared statement or function call.
+type Arg struct {
+	Format string `json:"format"`
+	Value  string `json:"value"`
+	// "drop" or "hash" if the value was redacted
+	Redacted string `json:"redacted,omitempty"`
+}
+
+// Relation is a table, view, sequence or index referenced by a statement.
+// Schema is only set if the statement qualifies the name.
+type Relation struct {
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+	// "read" or "write"
+	Access string `json:"access"`
+}
+
+// QualifiedName returns the name of the relation, prefixed with its schema
+// if known.
+func (r Relation) QualifiedName() string {
+	if r.Schema == "" {
+		return r.Name
+	}
+	return r.Schema + "." + r.Name
+}
+
+// Redaction notes how a statement was redacted before it was recorded.
+type Redaction struct {
+	// Literals in the query were replaced with placeholders
+	Normalized bool `json:"normalized,omitempty"`
+	// What was done to bound parameters and COPY data: "drop" or "hash"
+	Params string `json:"params,omitempty"`
+	// Names of the rules that redacted values
+	Rules []string `json:"rules,omitempty"`
+}
+
+// Limit describes a limit that was exceeded.
+type Limit struct {
+	// "rows" or "quota"
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Scope  string `json:"scope,omitempty"`
+	Limit  int64  `json:"limit"`
+	Used   int64  `json:"used,omitempty"`
+	Action string `json:"action,omitempty"`
+}
+
+// Connection describes the state of the connections behind a session
+// lifecycle event.
+type Connection struct {
+	TLSVersion  string `json:"tlsVersion,omitempty"`
+	CipherSuite string `json:"cipherSuite,omitempty"`
+	// Address of the backend mammoth connected to
+	Backend string `json:"backend,omitempty"`
+
+	// Totals, set on session.closed
+	DurationMs      int64  `json:"durationMs,omitempty"`
+	BytesFromClient int64  `json:"bytesFromClient,omitempty"`
+	BytesToClient   int64  `json:"bytesToClient,omitempty"`
+	Statements      int64  `json:"statements,omitempty"`
+	CloseReason     string `json:"closeReason,omitempty"`
+	// Path of the session recording
+	Recording string `json:"recording,omitempty"`
+}
+
+// StatementStats aggregates the executions of statements sharing a
+// fingerprint, by one user on one target. Times are in milliseconds, from
+// the statement being forwarded to the backend to its completion.
+type StatementStats struct {
+	// Same for statements differing only in their constants
+	Fingerprint string `json:"fingerprint"`
+	// The first statement seen, with its literals replaced by parameters
+	Query  string `json:"query"`
+	User   string `json:"user,omitempty"`
+	Target string `json:"target,omitempty"`
+
+	Calls int64 `json:"calls"`
+	// Executions that ended with an error
+	Errors int64 `json:"errors"`
+	// Rows returned or affected
+	Rows        int64   `json:"rows"`
+	TotalTimeMs float64 `json:"totalTimeMs"`
+	MeanTimeMs  float64 `json:"meanTimeMs"`
+	MinTimeMs   float64 `json:"minTimeMs"`
+	MaxTimeMs   float64 `json:"maxTimeMs"`
+
+	// When aggregation started: the first call, or the start of the
+	// interval for summaries. LastCall is when the last call completed.
+	Since    time.Time `json:"since"`
+	LastCall time.Time `json:"lastCall"`
+}
+
+// Outcome records what mammoth decided, and why.
+type Outcome struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// What went wrong, e.g. a message that could not be parsed or an
+	// error returned by the backend
+	Error string `json:"error,omitempty"`
+	// The error code the client got, for statements mammoth rejected,
+	// errors returned by the backend and failed authentications
+	SQLState string `json:"sqlstate,omitempty"`
+}
+
+// Alert reports that an alert rule fired. The alert event carries the
+// session, statement and outcome of the event that fired it.
+type Alert struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description,omitempty"`
+	Severity    string `json:"severity"`
+	// Matching events counted, the last of which fired the alert
+	Count    int       `json:"count"`
+	WindowMs int64     `json:"windowMs,omitempty"`
+	First    time.Time `json:"first"`
+	// Values of the fields the rule groups on
+	Group map[string]string `json:"group,omitempty"`
+	// Alerts held back for this rule and group since the last one sent
+	Suppressed int `json:"suppressed,omitempty"`
+	// Alerts of any rule dropped by the rate limit since the last one sent
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// Outage describes a period during which a sink could not deliver events.
+// Events written during an outage may be missing from that sink.
+type Outage struct {
+	// What the sink reported when the outage started
+	Error string    `json:"error"`
+	Since time.Time `json:"since"`
+	// Set on audit.recovered
+	DurationMs int64 `json:"durationMs,omitempty"`
+	// Statements rejected on fail-closed targets during the outage
+	Rejected int64 `json:"rejected,omitempty"`
+}
@@ -0,0 +1,367 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// Layout of the start time in segment file names, which sort in order.
+const segmentTimeLayout = "20060102T150405.000Z"
+
+// Upper bound on how often a FileSink checks for segments to rotate by age
+// and files past retention.
+const maxMaintenanceInterval = time.Minute
+
+// FileOptions controls how a FileSink splits its output into segments and
+// what happens to them once closed.
+type FileOptions struct {
+	// Close the current segment once it is this old...
+	SegmentInterval time.Duration
+	// ...or would grow beyond this many bytes. With neither, a single
+	// segment is kept until the sink is closed.
+	MaxSize int64
+	// Signs every closed segment, if set
+	SigningKey ed25519.PrivateKey
+	// "gzip" or "zstd" to compress closed segments
+	Compress string
+	// Delete closed segments this long after they were last written to
+	Retention time.Duration
+}
+
+// FileSink appends events to a file. When segmenting or signing, the file
+// is closed at the end of each segment and renamed after the time the
+// segment started, and a new file is started at the original path.
+type FileSink struct {
+	mtx  sync.Mutex
+	path string
+	f    Formatter
+	opts FileOptions
+	file *os.File
+
+	// Current segment
+	opened   time.Time
+	size     int64
+	records  int
+	from, to time.Time
+	// Of the last write
+	err error
+
+	stop        chan struct{}
+	done        chan struct{}
+	compressing sync.WaitGroup
+}
+
+// NewFileSink appends events to the file at path, creating it if needed.
+func NewFileSink(path string, f Formatter, opts FileOptions) (*FileSink, error) {
+	if opts.Compress != "" && compressExts[opts.Compress] == "" {
+		return nil, fmt.Errorf("Unknown compression: %s", opts.Compress)
+	}
+	s := &FileSink{path: path, f: f, opts: opts}
+
+	// What a previous run left behind can't be vouched for, so it is set
+	// aside as an unsigned segment
+	if s.segmented() {
+		if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+			segment, err := s.rename(fi.ModTime())
+			if err != nil {
+				return nil, err
+			}
+			s.compress(segment)
+		}
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	if opts.SegmentInterval > 0 || opts.Retention > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.maintain()
+	}
+	return s, nil
+}
+
+func (s *FileSink) segmented() bool {
+	return s.opts.SegmentInterval > 0 || s.opts.MaxSize > 0 || s.opts.SigningKey != nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.opened = time.Now()
+	s.size = fi.Size()
+	s.records = 0
+	s.from, s.to = time.Time{}, time.Time{}
+	return nil
+}
+
+func (s *FileSink) Write(e *Event) error {
+	line, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.err = s.write(e, line)
+	return s.err
+}
+
+// write must be called with mtx held.
+func (s *FileSink) write(e *Event, line []byte) error {
+	if s.file == nil {
+		return fmt.Errorf("Audit file %s is closed", s.path)
+	}
+	if s.records > 0 && (s.expired() || (s.opts.MaxSize > 0 && s.size+int64(len(line)) > s.opts.MaxSize)) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.records == 0 {
+		s.from = e.Time
+	}
+	s.to = e.Time
+	s.records++
+	return nil
+}
+
+// Check reports the error of the last write, if it failed. A file sink
+// recovers with its next successful write.
+func (s *FileSink) Check() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return fmt.Errorf("Error writing audit file %s: %w", s.path, s.err)
+	}
+	return nil
+}
+
+func (s *FileSink) expired() bool {
+	return s.opts.SegmentInterval > 0 && time.Since(s.opened) >= s.opts.SegmentInterval
+}
+
+// maintain rotates segments that are due even if nothing is written, and
+// deletes those past retention.
+func (s *FileSink) maintain() {
+	defer close(s.done)
+
+	interval := maxMaintenanceInterval
+	if s.opts.SegmentInterval > 0 && s.opts.SegmentInterval/2 < interval {
+		interval = s.opts.SegmentInterval / 2
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+
+		s.mtx.Lock()
+		if s.file != nil && s.records > 0 && s.expired() {
+			if err := s.rotate(); err != nil {
+				log.Errorf("Error rotating audit file %s: %v", s.path, err)
+			}
+		}
+		s.mtx.Unlock()
+
+		if s.opts.Retention > 0 {
+			s.prune()
+		}
+	}
+}
+
+// rotate closes the current segment and starts the next one. It must be
+// called with mtx held.
+func (s *FileSink) rotate() error {
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// closeSegment closes the current file, and unless it is empty, renames,
+// signs and compresses it.
+func (s *FileSink) closeSegment() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil || s.records == 0 {
+		return err
+	}
+
+	segment, err := s.rename(s.opened)
+	if err != nil {
+		return err
+	}
+	if s.opts.SigningKey != nil {
+		if err := signSegment(segment, s.opts.SigningKey, s.records, s.from, s.to); err != nil {
+			return err
+		}
+	}
+	s.compress(segment)
+	return nil
+}
+
+// compress compresses a closed segment in the background, if configured.
+func (s *FileSink) compress(segment string) {
+	if s.opts.Compress == "" {
+		return
+	}
+	s.compressing.Add(1)
+	go func() {
+		defer s.compressing.Done()
+		if _, err := compressFile(segment, s.opts.Compress); err != nil {
+			log.Errorf("Error compressing audit segment %s: %v", segment, err)
+		}
+	}()
+}
+
+// rename moves the file at the sink's path out of the way, naming it after
+// the given start time.
+func (s *FileSink) rename(start time.Time) (string, error) {
+	base := s.path + "." + start.UTC().Format(segmentTimeLayout)
+	segment := base
+	for i := 1; s.exists(segment); i++ {
+		segment = fmt.Sprintf("%s-%d", base, i)
+	}
+	return segment, os.Rename(s.path, segment)
+}
+
+// exists reports whether a segment by that name exists, compressed or not.
+func (s *FileSink) exists(segment string) bool {
+	for _, name := range []string{segment, segment + compressExts[CompressGzip], segment + compressExts[CompressZstd]} {
+		if _, err := os.Stat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// prune deletes closed segments, and their signatures, that were last
+// written to longer ago than the retention period.
+func (s *FileSink) prune() {
+	dir, prefix := filepath.Dir(s.path), filepath.Base(s.path)+"."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Errorf("Error listing audit segments in %s: %v", dir, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-s.opts.Retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isSegmentName(name, prefix) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || fi.IsDir() || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Errorf("Error deleting expired audit segment %s: %v", name, err)
+		}
+	}
+}
+
+// isSegmentName reports whether name is that of a segment, or of its
+// signature, of the file whose base name is prefix without the final dot.
+func isSegmentName(name, prefix string) bool {
+	// Segment names continue with their start time
+	return strings.HasPrefix(name, prefix) && len(name) > len(prefix) &&
+		name[len(prefix)] >= '0' && name[len(prefix)] <= '9'
+}
+
+// Segments returns the closed segments of the audit file at path,
+// compressed or not, oldest first.
+func Segments(path string) ([]string, error) {
+	dir, prefix := filepath.Dir(path), filepath.Base(path)+"."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isSegmentName(name, prefix) || strings.HasSuffix(name, SignatureExt) {
+			continue
+		}
+		segments = append(segments, filepath.Join(dir, name))
+	}
+	// Start times sort as text, once compression extensions are ignored
+	sort.Slice(segments, func(i, j int) bool {
+		return trimCompressExt(segments[i]) < trimCompressExt(segments[j])
+	})
+	return segments, nil
+}
+
+// Reopen starts a new file. When segmenting, the current segment is closed
+// as usual; otherwise the file is simply reopened, e.g. after it was moved
+// by logrotate.
+func (s *FileSink) Reopen() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	if s.segmented() {
+		return s.rotate()
+	}
+	if err := s.file.Close(); err != nil {
+		log.Errorf("Error closing audit file %s: %v", s.path, err)
+	}
+	return s.open()
+}
+
+// Close closes the file, closing it as a final segment if needed, and waits
+// for compression to finish.
+func (s *FileSink) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	s.mtx.Lock()
+	var err error
+	if s.file != nil {
+		if s.segmented() {
+			err = s.closeSegment()
+		} else {
+			err = s.file.Close()
+			s.file = nil
+		}
+	}
+	s.mtx.Unlock()
+
+	s.compressing.Wait()
+	return err
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// How often the auditor checks whether an outage is over when nothing
+// else asks.
+const healthCheckInterval = time.Second
+
+// Check reports whether every sink is able to deliver events right now,
+// returning the first problem found. The start and end of an outage are
+// audited, for the sinks that can still receive them.
+func (a *Auditor) Check() error {
+	var err error
+	for _, s := range a.sinks {
+		if c, ok := s.(Checker); ok {
+			if err = c.Check(); err != nil {
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	var event *Event
+	a.outageMtx.Lock()
+	switch {
+	case err != nil && a.outage == nil:
+		a.outage = &Outage{Error: err.Error(), Since: now.UTC()}
+		o := *a.outage
+		event = &Event{Type: EventAuditUnavailable, Outage: &o}
+	case err == nil && a.outage != nil:
+		o := *a.outage
+		o.DurationMs = now.Sub(o.Since).Milliseconds()
+		a.outage = nil
+		event = &Event{Type: EventAuditRecovered, Outage: &o}
+	}
+	a.outageMtx.Unlock()
+
+	if event != nil {
+		if err != nil {
+			log.Errorf("Audit events can't be delivered: %v", err)
+		} else {
+			log.Infof("Audit events can be delivered again after %s", time.Duration(event.Outage.DurationMs)*time.Millisecond)
+		}
+		event.Time = now.UTC()
+		if emitErr := a.Emit(event); emitErr != nil {
+			log.Debugf("Unable to write audit event: %v", emitErr)
+		}
+	}
+	return err
+}
+
+// CountRejected counts a statement rejected because of the ongoing outage,
+// for the event recording its end.
+func (a *Auditor) CountRejected() {
+	a.outageMtx.Lock()
+	if a.outage != nil {
+		a.outage.Rejected++
+	}
+	a.outageMtx.Unlock()
+}
+
+// monitor checks the sinks periodically, so that outages are recorded even
+// when no fail-closed session asks.
+func (a *Auditor) monitor() {
+	defer a.wg.Done()
+	t := time.NewTicker(healthCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-t.C:
+			a.Check()
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// NewSessionID returns a random session identifier. Should the random
+// source fail, it falls back on the current time, which is still unique
+// enough to correlate the events of one mammoth instance.
+func NewSessionID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
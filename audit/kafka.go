@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunopadz/mammoth/util/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// KafkaOptions configures a KafkaSink.
+type KafkaOptions struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+	// TLS is used when set
+	TLSConfig *tls.Config
+	// "PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512"; empty disables SASL
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+	// "gzip", "snappy", "lz4", "zstd" or "none"
+	Compress string
+	// Number of events held while the brokers are unreachable
+	BufferSize int
+}
+
+// KafkaSink produces events to a Kafka topic, keyed by session ID so the
+// events of one session land on one partition in order. Producing is
+// idempotent, so retries never duplicate or reorder records.
+type KafkaSink struct {
+	opts    KafkaOptions
+	f       Formatter
+	client  *kgo.Client
+	failing atomic.Bool
+	dropped atomic.Int64
+}
+
+func NewKafkaSink(f Formatter, opts KafkaOptions) (*KafkaSink, error) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "mammoth"
+	}
+
+	s := &KafkaSink{opts: opts, f: f}
+
+	kopts := []kgo.Opt{
+		kgo.SeedBrokers(opts.Brokers...),
+		kgo.DefaultProduceTopic(opts.Topic),
+		kgo.ClientID(opts.ClientID),
+		kgo.MaxBufferedRecords(opts.BufferSize),
+		// Idempotent producing requires acks from all in-sync replicas
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.WithHooks(s),
+	}
+	if opts.TLSConfig != nil {
+		kopts = append(kopts, kgo.DialTLSConfig(opts.TLSConfig))
+	}
+	if opts.SASLMechanism != "" {
+		m, err := kafkaSASL(opts.SASLMechanism, opts.SASLUsername, opts.SASLPassword)
+		if err != nil {
+			return nil, err
+		}
+		kopts = append(kopts, kgo.SASL(m))
+	}
+	if opts.Compress != "" {
+		codec, err := kafkaCompression(opts.Compress)
+		if err != nil {
+			return nil, err
+		}
+		kopts = append(kopts, kgo.ProducerBatchCompression(codec))
+	}
+
+	client, err := kgo.NewClient(kopts...)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	return s, nil
+}
+
+func kafkaSASL(mechanism, user, password string) (sasl.Mechanism, error) {
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		return plain.Auth{User: user, Pass: password}.AsMechanism(), nil
+	case "SCRAM-SHA-256":
+		return scram.Auth{User: user, Pass: password}.AsSha256Mechanism(), nil
+	case "SCRAM-SHA-512":
+		return scram.Auth{User: user, Pass: password}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("Unknown SASL mechanism: %s", mechanism)
+}
+
+func kafkaCompression(name string) (kgo.CompressionCodec, error) {
+	switch name {
+	case "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	}
+	return kgo.CompressionCodec{}, fmt.Errorf("Unknown compression: %s", name)
+}
+
+// Write queues e for delivery.
+func (s *KafkaSink) Write(e *Event) error {
+	if s.client.BufferedProduceRecords() >= int64(s.opts.BufferSize) {
+		return ErrBufferFull
+	}
+
+	value, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+	r := &kgo.Record{
+		Value:   value,
+		Headers: []kgo.RecordHeader{{Key: "type", Value: []byte(e.Type)}},
+	}
+	// Checkpoints belong to no session and are spread over the partitions
+	if e.Session != nil && e.Session.ID != "" {
+		r.Key = []byte(e.Session.ID)
+	}
+	s.client.TryProduce(context.Background(), r, s.produced)
+	return nil
+}
+
+// Check reports whether the brokers are reachable and the buffer has room.
+func (s *KafkaSink) Check() error {
+	if s.client.BufferedProduceRecords() >= int64(s.opts.BufferSize) {
+		return ErrBufferFull
+	}
+	if s.failing.Load() {
+		return fmt.Errorf("Kafka brokers for topic %s unreachable", s.opts.Topic)
+	}
+	return nil
+}
+
+func (s *KafkaSink) produced(r *kgo.Record, err error) {
+	if err == nil {
+		return
+	}
+	s.dropped.Add(1)
+	switch {
+	case errors.Is(err, kgo.ErrClientClosed):
+		// Counted and reported by Close
+	case errors.Is(err, kgo.ErrMaxBuffered):
+		log.Errorf("Error sending audit event to Kafka topic %s: %v", s.opts.Topic, ErrBufferFull)
+	default:
+		log.Errorf("Error sending audit event to Kafka topic %s: %v", s.opts.Topic, err)
+	}
+}
+
+// OnBrokerConnect logs when the brokers become unreachable and when they
+// recover. Records are retried in the meantime.
+func (s *KafkaSink) OnBrokerConnect(meta kgo.BrokerMetadata, _ time.Duration, _ net.Conn, err error) {
+	if err != nil {
+		if !s.failing.Swap(true) {
+			log.Errorf("Error connecting to Kafka broker %s: %v", net.JoinHostPort(meta.Host, fmt.Sprint(meta.Port)), err)
+		}
+		return
+	}
+	if s.failing.Swap(false) {
+		log.Infof("Audit Kafka broker %s reachable again", net.JoinHostPort(meta.Host, fmt.Sprint(meta.Port)))
+	}
+}
+
+// Close delivers what is still buffered, giving up after a while.
+func (s *KafkaSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	s.client.Flush(ctx)
+	s.client.Close()
+
+	if n := s.dropped.Load(); n > 0 {
+		return fmt.Errorf("%d audit event(s) could not be delivered to Kafka topic %s", n, s.opts.Topic)
+	}
+	return nil
+}
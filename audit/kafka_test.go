@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// TestKafkaSinkIntegration produces events to a real broker and reads them
+// back. It only runs with MAMMOTH_TEST_KAFKA_BROKERS set to a comma
+// separated list of brokers, e.g. with the Redpanda container described in
+// the README:
+//
+//	MAMMOTH_TEST_KAFKA_BROKERS=127.0.0.1:9092 go test -run Kafka ./audit
+//
+// It creates a topic of its own, and deletes it afterwards.
+func TestKafkaSinkIntegration(t *testing.T) {
+	env := os.Getenv("MAMMOTH_TEST_KAFKA_BROKERS")
+	if env == "" {
+		t.Skip("MAMMOTH_TEST_KAFKA_BROKERS not set")
+	}
+	brokers := strings.Split(env, ",")
+	topic := fmt.Sprintf("mammoth-audit-test-%d", time.Now().UnixNano())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	admin, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	createTopic(ctx, t, admin, topic, 3)
+	defer deleteTopic(admin, topic)
+
+	s, err := NewKafkaSink(JSONFormatter{}, KafkaOptions{Brokers: brokers, Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*Event{testEvent("a"), testEvent("b"), testEvent("a"), testEvent("a")}
+	for i, e := range events {
+		e.Outcome.Reason = fmt.Sprint(i)
+	}
+	events = append(events, &Event{Version: SchemaVersion, Time: time.Now(), Type: EventCheckpoint})
+	for _, e := range events {
+		if err := s.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := kgo.NewClient(
+		kgo.SeedBrokers(brokers...),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+
+	var records []*kgo.Record
+	for len(records) < len(events) {
+		fetches := consumer.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("%d of %d records consumed: %v", len(records), len(events), err)
+		}
+		fetches.EachError(func(_ string, _ int32, err error) {
+			t.Fatal(err)
+		})
+		records = append(records, fetches.Records()...)
+	}
+
+	// The events of a session share a partition, in order
+	partition := map[string]int32{}
+	var order []string
+	for _, r := range records {
+		if len(r.Headers) != 1 || r.Headers[0].Key != "type" {
+			t.Errorf("unexpected headers: %v", r.Headers)
+			continue
+		}
+		typ := string(r.Headers[0].Value)
+		if typ == EventCheckpoint {
+			if r.Key != nil {
+				t.Errorf("checkpoint record has key %q", r.Key)
+			}
+			continue
+		}
+
+		key := string(r.Key)
+		if p, ok := partition[key]; ok && p != r.Partition {
+			t.Errorf("session %s spans partitions %d and %d", key, p, r.Partition)
+		}
+		partition[key] = r.Partition
+		if key == "a" {
+			order = append(order, string(r.Value))
+		}
+	}
+	if len(order) != 3 {
+		t.Fatalf("%d records of session a, want 3", len(order))
+	}
+	for i, want := range []string{`"reason":"0"`, `"reason":"2"`, `"reason":"3"`} {
+		if !strings.Contains(order[i], want) {
+			t.Errorf("record %d of session a: %s, want %s", i, order[i], want)
+		}
+	}
+}
+
+func createTopic(ctx context.Context, t *testing.T, client *kgo.Client, topic string, partitions int32) {
+	t.Helper()
+
+	req := kmsg.NewPtrCreateTopicsRequest()
+	rt := kmsg.NewCreateTopicsRequestTopic()
+	rt.Topic = topic
+	rt.NumPartitions = partitions
+	rt.ReplicationFactor = 1
+	req.Topics = append(req.Topics, rt)
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range resp.Topics {
+		if err := kerr.ErrorForCode(rt.ErrorCode); err != nil {
+			t.Fatalf("Unable to create topic %s: %v", topic, err)
+		}
+	}
+}
+
+func deleteTopic(client *kgo.Client, topic string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := kmsg.NewPtrDeleteTopicsRequest()
+	req.TopicNames = []string{topic}
+	rt := kmsg.NewDeleteTopicsRequestTopic()
+	rt.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, rt)
+	req.RequestWith(ctx, client)
+}
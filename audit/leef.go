@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// devTime layout, and the same layout in the Java notation LEEF expects.
+const (
+	leefTimeLayout = "2006-01-02T15:04:05.000-0700"
+	leefTimeFormat = "yyyy-MM-dd'T'HH:mm:ss.SSSZ"
+)
+
+var (
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	leefValueEscaper  = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+)
+
+// LEEFFormatter writes events in QRadar's Log Event Extended Format 1.0,
+// with tab-separated attributes. Fields without a predefined LEEF key are
+// added as custom keys named like the JSON fields.
+type LEEFFormatter struct{}
+
+func (LEEFFormatter) ContentType() string {
+	return "text/plain"
+}
+
+type leefAttributes struct {
+	bytes.Buffer
+}
+
+func (x *leefAttributes) add(key, value string) {
+	if value == "" {
+		return
+	}
+	if x.Len() > 0 {
+		x.WriteByte('\t')
+	}
+	x.WriteString(key)
+	x.WriteByte('=')
+	x.WriteString(leefValueEscaper.Replace(value))
+}
+
+// addr adds a host under ipKey if it is an IP address and nameKey
+// otherwise, and its port under portKey.
+func (x *leefAttributes) addr(addr, ipKey, nameKey, portKey string) {
+	host, port := splitAddr(addr)
+	if isIP(host) {
+		x.add(ipKey, host)
+	} else {
+		x.add(nameKey, host)
+	}
+	x.add(portKey, formatInt(int64(port)))
+}
+
+func (LEEFFormatter) Format(e *Event) ([]byte, error) {
+	x := &leefAttributes{}
+	x.add("devTime", e.Time.Format(leefTimeLayout))
+	x.add("devTimeFormat", leefTimeFormat)
+	x.add("cat", e.Type)
+	// Same 1-10 scale as CEF
+	x.add("sev", strconv.Itoa(cefSeverity(Level(e))))
+
+	if s := e.Session; s != nil {
+		x.add("sessionId", s.ID)
+		x.add("usrName", s.User)
+		x.addr(s.Client, "src", "srcHost", "srcPort")
+		x.addr(s.Server, "dst", "dstHost", "dstPort")
+		x.add("database", s.Database)
+		x.add("target", s.Target)
+		if s.BreakGlass {
+			x.add("breakGlass", "true")
+			x.add("breakGlassReason", s.BreakGlassReason)
+		}
+	}
+
+	if s := e.Statement; s != nil {
+		x.add("message", s.Message)
+		x.add("classes", strings.Join(s.Classes, ","))
+		x.add("query", s.Query)
+		x.add("preparedStatement", s.PreparedStatement)
+		x.add("portal", s.Portal)
+		if len(s.Args) > 0 {
+			args, err := json.Marshal(s.Args)
+			if err != nil {
+				return nil, err
+			}
+			x.add("args", string(args))
+		}
+		var relations []string
+		for _, r := range s.Relations {
+			relations = append(relations, r.QualifiedName()+":"+r.Access)
+		}
+		x.add("relations", strings.Join(relations, ","))
+		x.add("functionOid", formatInt(int64(s.FunctionOID)))
+		x.add("object", s.Object)
+		x.add("maxRows", formatInt(int64(s.MaxRows)))
+		x.add("data", s.Data)
+		x.add("errorMessage", s.ErrorMessage)
+		x.add("code", formatInt(int64(s.Code)))
+		x.add("length", formatInt(int64(s.Length)))
+	}
+
+	if l := e.Limit; l != nil {
+		x.add("limitKind", l.Kind)
+		x.add("limitName", l.Name)
+		x.add("limitScope", l.Scope)
+		x.add("limit", strconv.FormatInt(l.Limit, 10))
+		x.add("used", formatInt(l.Used))
+		x.add("action", l.Action)
+	}
+
+	if c := e.Connection; c != nil {
+		x.add("tlsVersion", c.TLSVersion)
+		x.add("cipherSuite", c.CipherSuite)
+		x.add("backend", c.Backend)
+		x.add("durationMs", formatInt(c.DurationMs))
+		x.add("srcBytes", formatInt(c.BytesFromClient))
+		x.add("dstBytes", formatInt(c.BytesToClient))
+		x.add("statements", formatInt(c.Statements))
+		x.add("closeReason", c.CloseReason)
+	}
+
+	if o := e.Outcome; o != nil {
+		x.add("decision", o.Decision)
+		x.add("reason", o.Reason)
+		x.add("severity", o.Severity)
+		x.add("error", o.Error)
+		x.add("sqlstate", o.SQLState)
+	}
+
+	if st := e.Stats; st != nil {
+		x.add("fingerprint", st.Fingerprint)
+		x.add("query", st.Query)
+		x.add("usrName", st.User)
+		x.add("target", st.Target)
+		x.add("calls", strconv.FormatInt(st.Calls, 10))
+		x.add("errors", strconv.FormatInt(st.Errors, 10))
+		x.add("rows", strconv.FormatInt(st.Rows, 10))
+		x.add("totalTimeMs", formatMs(st.TotalTimeMs))
+		x.add("meanTimeMs", formatMs(st.MeanTimeMs))
+		x.add("minTimeMs", formatMs(st.MinTimeMs))
+		x.add("maxTimeMs", formatMs(st.MaxTimeMs))
+		x.add("since", st.Since.Format(leefTimeLayout))
+		x.add("lastCall", st.LastCall.Format(leefTimeLayout))
+	}
+
+	if a := e.Alert; a != nil {
+		x.add("rule", a.Rule)
+		x.add("description", a.Description)
+		x.add("alertSeverity", a.Severity)
+		x.add("count", strconv.Itoa(a.Count))
+		x.add("windowMs", formatInt(a.WindowMs))
+		x.add("first", a.First.Format(leefTimeLayout))
+		x.add("suppressed", formatInt(int64(a.Suppressed)))
+		x.add("dropped", formatInt(int64(a.Dropped)))
+	}
+
+	if o := e.Outage; o != nil {
+		x.add("outageError", o.Error)
+		x.add("outageSince", o.Since.Format(leefTimeLayout))
+		x.add("outageMs", formatInt(o.DurationMs))
+		x.add("rejected", formatInt(o.Rejected))
+	}
+
+	if c := e.Checkpoint; c != nil {
+		x.add("chain", c.Chain)
+		x.add("checkpointReason", c.Reason)
+	}
+	if e.Hash != "" {
+		x.add("seq", strconv.FormatUint(e.Seq, 10))
+		x.add("prevHash", e.PrevHash)
+		x.add("hash", e.Hash)
+	}
+
+	header := strings.Join([]string{
+		"LEEF:1.0",
+		productVendor,
+		productName,
+		productVersion,
+		leefHeaderEscaper.Replace(e.Type),
+	}, "|")
+	return append([]byte(header+"|"), x.Bytes()...), nil
+}
@@ -0,0 +1,50 @@
+package audit
+
+import "github.com/brunopadz/mammoth/config"
+
+// Outcome severities: high for statements that reach the database host
+// itself, alert for statements of classes configured to alert on.
+const (
+	SeverityHigh  = "high"
+	SeverityAlert = "alert"
+)
+
+// Levels rank events for sinks and formats that need a severity. They are
+// syslog severities.
+const (
+	LevelCritical = 2
+	LevelWarning  = 4
+	LevelNotice   = 5
+	LevelInfo     = 6
+)
+
+// Level returns how severe an event is.
+func Level(e *Event) int {
+	switch {
+	case e.Alert != nil && e.Alert.Severity == config.AlertSeverityCritical:
+		return LevelCritical
+	case e.Alert != nil:
+		return LevelWarning
+	case e.Type == EventAuditUnavailable:
+		return LevelCritical
+	case e.Type == EventAuditRecovered:
+		return LevelNotice
+	case e.Outcome != nil && e.Outcome.Severity == SeverityHigh:
+		return LevelCritical
+	case e.Outcome != nil && e.Outcome.Decision == DecisionRejected:
+		return LevelWarning
+	case e.Outcome != nil && e.Outcome.Severity == SeverityAlert:
+		return LevelWarning
+	case e.Type == EventLimit:
+		return LevelWarning
+	case e.Type == EventSessionBreakGlass:
+		return LevelWarning
+	case e.Type == EventSessionPolicy && e.Session != nil && e.Session.BreakGlass:
+		return LevelWarning
+	case e.Outcome != nil && e.Outcome.Decision == DecisionConfirmed:
+		return LevelNotice
+	case e.Type == EventStatementError:
+		return LevelNotice
+	}
+	return LevelInfo
+}
@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"encoding/json"
+)
+
+// OCSF schema version and the Datastore Activity class events map onto.
+const (
+	ocsfVersion      = "1.1.0"
+	ocsfCategoryUID  = 6
+	ocsfCategoryName = "Application Activity"
+	ocsfClassUID     = 6005
+	ocsfClassName    = "Datastore Activity"
+)
+
+// Datastore Activity activity IDs.
+const (
+	ocsfActivityQuery = 8
+	ocsfActivityOther = 99
+)
+
+// OCSFFormatter writes events as OCSF Datastore Activity events. Statements
+// are Query activities; every other event type is an Other activity named
+// after it. Fields OCSF has no attribute for go into "unmapped".
+type OCSFFormatter struct{}
+
+func (OCSFFormatter) ContentType() string {
+	return "application/json"
+}
+
+type ocsfEvent struct {
+	ActivityID   int    `json:"activity_id"`
+	ActivityName string `json:"activity_name"`
+	CategoryUID  int    `json:"category_uid"`
+	CategoryName string `json:"category_name"`
+	ClassUID     int    `json:"class_uid"`
+	ClassName    string `json:"class_name"`
+	TypeUID      int    `json:"type_uid"`
+	TypeName     string `json:"type_name"`
+	// Datastore type, always Database
+	TypeID int    `json:"type_id"`
+	Type   string `json:"type"`
+
+	Time         int64  `json:"time"`
+	SeverityID   int    `json:"severity_id"`
+	Severity     string `json:"severity"`
+	StatusID     int    `json:"status_id,omitempty"`
+	Status       string `json:"status,omitempty"`
+	StatusCode   string `json:"status_code,omitempty"`
+	StatusDetail string `json:"status_detail,omitempty"`
+	ActionID     int    `json:"action_id,omitempty"`
+	Action       string `json:"action,omitempty"`
+	Message      string `json:"message,omitempty"`
+	Duration     int64  `json:"duration,omitempty"`
+
+	Metadata    ocsfMetadata   `json:"metadata"`
+	Actor       *ocsfActor     `json:"actor,omitempty"`
+	SrcEndpoint *ocsfEndpoint  `json:"src_endpoint,omitempty"`
+	DstEndpoint *ocsfEndpoint  `json:"dst_endpoint,omitempty"`
+	Database    *ocsfDatabase  `json:"database,omitempty"`
+	QueryInfo   *ocsfQueryInfo `json:"query_info,omitempty"`
+	Unmapped    *ocsfUnmapped  `json:"unmapped,omitempty"`
+}
+
+type ocsfMetadata struct {
+	Version        string      `json:"version"`
+	Product        ocsfProduct `json:"product"`
+	LogName        string      `json:"log_name"`
+	EventCode      string      `json:"event_code"`
+	CorrelationUID string      `json:"correlation_uid,omitempty"`
+	UID            string      `json:"uid,omitempty"`
+	Sequence       uint64      `json:"sequence,omitempty"`
+}
+
+type ocsfProduct struct {
+	Name       string `json:"name"`
+	VendorName string `json:"vendor_name"`
+	Version    string `json:"version"`
+}
+
+type ocsfActor struct {
+	User    *ocsfUser    `json:"user,omitempty"`
+	Session *ocsfSession `json:"session,omitempty"`
+}
+
+type ocsfUser struct {
+	Name string `json:"name"`
+}
+
+type ocsfSession struct {
+	UID string `json:"uid"`
+}
+
+type ocsfEndpoint struct {
+	IP       string `json:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port,omitempty"`
+}
+
+type ocsfDatabase struct {
+	Name   string `json:"name"`
+	TypeID int    `json:"type_id"`
+	Type   string `json:"type"`
+}
+
+type ocsfQueryInfo struct {
+	QueryString string `json:"query_string,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+// ocsfUnmapped keeps the mammoth fields OCSF has no attribute for, in the
+// shape of the JSON format.
+type ocsfUnmapped struct {
+	Target           string          `json:"target,omitempty"`
+	BreakGlass       bool            `json:"breakGlass,omitempty"`
+	BreakGlassReason string          `json:"breakGlassReason,omitempty"`
+	Statement        *Statement      `json:"statement,omitempty"`
+	Limit            *Limit          `json:"limit,omitempty"`
+	Connection       *Connection     `json:"connection,omitempty"`
+	Reason           string          `json:"reason,omitempty"`
+	Severity         string          `json:"severity,omitempty"`
+	Checkpoint       *Checkpoint     `json:"checkpoint,omitempty"`
+	Stats            *StatementStats `json:"stats,omitempty"`
+	Alert            *Alert          `json:"alert,omitempty"`
+	Outage           *Outage         `json:"outage,omitempty"`
+	PrevHash         string          `json:"prevHash,omitempty"`
+}
+
+func ocsfEndpointFor(addr string) *ocsfEndpoint {
+	if addr == "" {
+		return nil
+	}
+	host, port := splitAddr(addr)
+	ep := &ocsfEndpoint{Port: port}
+	if isIP(host) {
+		ep.IP = host
+	} else {
+		ep.Hostname = host
+	}
+	return ep
+}
+
+// ocsfSeverity maps levels onto OCSF severity IDs.
+func ocsfSeverity(level int) (int, string) {
+	switch level {
+	case LevelCritical:
+		return 5, "Critical"
+	case LevelWarning:
+		return 3, "Medium"
+	case LevelNotice:
+		return 2, "Low"
+	}
+	return 1, "Informational"
+}
+
+func (OCSFFormatter) Format(e *Event) ([]byte, error) {
+	o := ocsfEvent{
+		ActivityID:   ocsfActivityOther,
+		ActivityName: e.Type,
+		CategoryUID:  ocsfCategoryUID,
+		CategoryName: ocsfCategoryName,
+		ClassUID:     ocsfClassUID,
+		ClassName:    ocsfClassName,
+		TypeID:       1,
+		Type:         "Database",
+		Time:         e.Time.UnixMilli(),
+		Message:      eventName(e),
+		Metadata: ocsfMetadata{
+			Version: ocsfVersion,
+			Product: ocsfProduct{
+				Name:       productName,
+				VendorName: productVendor,
+				Version:    productVersion,
+			},
+			LogName:   "audit",
+			EventCode: e.Type,
+			UID:       e.Hash,
+			Sequence:  e.Seq,
+		},
+		Unmapped: &ocsfUnmapped{
+			Limit:      e.Limit,
+			Connection: e.Connection,
+			Checkpoint: e.Checkpoint,
+			Stats:      e.Stats,
+			Alert:      e.Alert,
+			Outage:     e.Outage,
+			PrevHash:   e.PrevHash,
+		},
+	}
+	if e.Type == EventStatement || e.Type == EventStatementError {
+		o.ActivityID = ocsfActivityQuery
+		o.ActivityName = "Query"
+	}
+	o.TypeUID = ocsfClassUID*100 + o.ActivityID
+	o.TypeName = ocsfClassName + ": " + o.ActivityName
+	o.SeverityID, o.Severity = ocsfSeverity(Level(e))
+
+	if s := e.Session; s != nil {
+		o.Metadata.CorrelationUID = s.ID
+		o.Actor = &ocsfActor{Session: &ocsfSession{UID: s.ID}}
+		if s.User != "" {
+			o.Actor.User = &ocsfUser{Name: s.User}
+		}
+		o.SrcEndpoint = ocsfEndpointFor(s.Client)
+		o.DstEndpoint = ocsfEndpointFor(s.Server)
+		if s.Database != "" {
+			o.Database = &ocsfDatabase{Name: s.Database, TypeID: 1, Type: "Relational"}
+		}
+		o.Unmapped.Target = s.Target
+		o.Unmapped.BreakGlass = s.BreakGlass
+		o.Unmapped.BreakGlassReason = s.BreakGlassReason
+	}
+
+	if s := e.Statement; s != nil {
+		if s.Query != "" || s.PreparedStatement != "" {
+			o.QueryInfo = &ocsfQueryInfo{QueryString: s.Query, Name: s.PreparedStatement}
+		}
+		o.Unmapped.Statement = s
+	}
+
+	if st := e.Stats; st != nil {
+		if st.User != "" {
+			o.Actor = &ocsfActor{User: &ocsfUser{Name: st.User}}
+		}
+		o.QueryInfo = &ocsfQueryInfo{QueryString: st.Query}
+	}
+
+	if c := e.Connection; c != nil {
+		o.Duration = c.DurationMs
+	}
+
+	if out := e.Outcome; out != nil {
+		switch out.Decision {
+		case DecisionRejected:
+			o.ActionID, o.Action = 2, "Denied"
+			o.StatusID, o.Status = 2, "Failure"
+		default:
+			o.ActionID, o.Action = 1, "Allowed"
+			o.StatusID, o.Status = 1, "Success"
+		}
+		if out.Error != "" || out.SQLState != "" {
+			o.StatusID, o.Status = 2, "Failure"
+		}
+		o.StatusCode = out.SQLState
+		o.StatusDetail = out.Error
+		o.Unmapped.Reason = out.Reason
+		o.Unmapped.Severity = out.Severity
+	}
+
+	if *o.Unmapped == (ocsfUnmapped{}) {
+		o.Unmapped = nil
+	}
+	return json.Marshal(o)
+}
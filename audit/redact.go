@@ -0,0 +1,249 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// Redaction actions.
+const (
+	RedactKeep = "keep"
+	RedactDrop = "drop"
+	RedactHash = "hash"
+)
+
+// Stands in for dropped literals, so the query remains valid SQL.
+const redactedLiteral = "'[redacted]'"
+
+// Redactor removes sensitive values from statements before they are
+// recorded. It remembers the columns of the parameters of each prepared
+// statement, so that column rules apply to the values later bound to
+// them. It is not safe for concurrent use.
+type Redactor struct {
+	c config.AuditRedaction
+	// Columns by parameter number, by statement name, by session
+	prepared map[string]map[string]map[int]string
+}
+
+// NewRedactor returns a redactor, or nil if the configuration doesn't
+// redact anything.
+func NewRedactor(c config.AuditRedaction) *Redactor {
+	if !c.Normalize && (c.Params == "" || c.Params == RedactKeep) && len(c.Rules) == 0 {
+		return nil
+	}
+	return &Redactor{
+		c:        c,
+		prepared: map[string]map[string]map[int]string{},
+	}
+}
+
+// Redact redacts the statement of e, if any. The statement is copied
+// rather than modified in place. The message of an error the backend
+// returned for a statement may quote the values redacted from it, so
+// only its SQLSTATE is kept.
+func (r *Redactor) Redact(e *Event) {
+	var session string
+	if e.Session != nil {
+		session = e.Session.ID
+	}
+	if e.Type == EventSessionClosed {
+		delete(r.prepared, session)
+		return
+	}
+	if e.Type == EventStatementError && e.Outcome != nil {
+		o := *e.Outcome
+		o.Error = ""
+		e.Outcome = &o
+	}
+	if e.Statement == nil {
+		return
+	}
+
+	s := *e.Statement
+	e.Statement = &s
+	red := &Redaction{}
+	fired := func(rule *config.RedactionRule) {
+		for _, name := range red.Rules {
+			if name == rule.Name {
+				return
+			}
+		}
+		red.Rules = append(red.Rules, rule.Name)
+	}
+
+	switch {
+	case e.Type == EventStatementError:
+		// The query of a statement audited before, whatever message ran it
+		if s.Query != "" {
+			s.Query = r.redactQuery(s.Query, "", "", fired)
+			red.Normalized = r.c.Normalize
+		}
+	case s.Message == "Parse":
+		s.Query = r.redactQuery(s.Query, session, s.PreparedStatement, fired)
+		red.Normalized = r.c.Normalize
+	case s.Message == "SimpleQuery":
+		s.Query = r.redactQuery(s.Query, "", "", fired)
+		red.Normalized = r.c.Normalize
+	case s.Message == "Close":
+		if s.Object == "prepared" {
+			r.forget(session, s.PreparedStatement)
+		}
+	}
+
+	if len(s.Args) > 0 {
+		var columns map[int]string
+		if s.Message == "Bind" {
+			columns = r.prepared[session][s.PreparedStatement]
+		}
+		args := make([]Arg, len(s.Args))
+		for i, arg := range s.Args {
+			if arg.Format == "null" {
+				args[i] = arg
+				continue
+			}
+			rule := r.matchRule(columns[i+1], arg.Value)
+			action := r.c.Params
+			if rule != nil {
+				action = rule.Action
+				fired(rule)
+			} else if action != RedactKeep {
+				red.Params = action
+			}
+			args[i] = arg
+			if action != RedactKeep {
+				args[i].Value = r.apply(action, arg.Value)
+				args[i].Redacted = action
+			}
+		}
+		s.Args = args
+	}
+
+	if s.Data != "" && r.c.Params != RedactKeep {
+		s.Data = r.apply(r.c.Params, s.Data)
+		red.Params = r.c.Params
+	}
+
+	if red.Normalized || red.Params != "" || len(red.Rules) > 0 {
+		s.Redaction = red
+	}
+}
+
+// redactQuery applies the rules, and normalisation if enabled, to q. For a
+// Parse message, it remembers the columns of the parameters.
+func (r *Redactor) redactQuery(q, session, name string, fired func(*config.RedactionRule)) string {
+	if session != "" {
+		r.forget(session, name)
+	}
+
+	redact := map[int]*config.RedactionRule{}
+	for _, stmt := range query.Split(q) {
+		for _, v := range stmt.Values() {
+			if n := v.Token.ParamNumber(); n > 0 {
+				if session != "" && v.Column != "" {
+					r.remember(session, name, n, v.Column)
+				}
+				continue
+			}
+
+			value := v.Token.Text
+			if v.Token.Kind == query.String {
+				value = query.StringValue(v.Token)
+			}
+			rule := r.matchRule(v.Column, value)
+			if rule != nil && !r.c.Normalize {
+				redact[v.Token.Pos] = rule
+			}
+		}
+	}
+
+	if r.c.Normalize {
+		return query.Normalize(q)
+	}
+	if len(redact) == 0 {
+		return q
+	}
+
+	var literals []query.Token
+	for _, t := range query.Lex(q) {
+		if redact[t.Pos] != nil && t.IsValue() {
+			literals = append(literals, t)
+		}
+	}
+	return query.Rewrite(q, literals, func(t query.Token) string {
+		rule := redact[t.Pos]
+		fired(rule)
+		if rule.Action == RedactHash {
+			return "'" + r.hash(query.StringValue(t)) + "'"
+		}
+		return redactedLiteral
+	})
+}
+
+// matchRule returns the first rule that applies to a value of the given
+// column, which may be unknown.
+func (r *Redactor) matchRule(column, value string) *config.RedactionRule {
+	for i := range r.c.Rules {
+		rule := &r.c.Rules[i]
+		if len(rule.Columns) > 0 && !containsString(rule.Columns, column) {
+			continue
+		}
+		if rule.Pattern != nil && !rule.Pattern.MatchString(value) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) apply(action, value string) string {
+	if action == RedactHash {
+		return r.hash(value)
+	}
+	return ""
+}
+
+// hash returns a digest of value that can be compared with others, keyed
+// if a hash key is configured so that short values can't be guessed.
+func (r *Redactor) hash(value string) string {
+	if len(r.c.HashKey) == 0 {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, r.c.HashKey)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Redactor) remember(session, name string, param int, column string) {
+	stmts := r.prepared[session]
+	if stmts == nil {
+		stmts = map[string]map[int]string{}
+		r.prepared[session] = stmts
+	}
+	if stmts[name] == nil {
+		stmts[name] = map[int]string{}
+	}
+	stmts[name][param] = column
+}
+
+func (r *Redactor) forget(session, name string) {
+	if stmts := r.prepared[session]; stmts != nil {
+		delete(stmts, name)
+		if len(stmts) == 0 {
+			delete(r.prepared, session)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/brunopadz/mammoth/config"
+)
+
+func TestRedactStatementError(t *testing.T) {
+	r := NewRedactor(config.AuditRedaction{Normalize: true, Params: RedactKeep})
+	outcome := &Outcome{Decision: DecisionAllowed, Error: `invalid input syntax for type integer: "s3cret"`, SQLState: "22P02"}
+	e := &Event{
+		Type:      EventStatementError,
+		Statement: &Statement{Message: "Execute", Query: "SELECT * FROM t WHERE id = 's3cret'"},
+		Outcome:   outcome,
+	}
+	r.Redact(e)
+
+	if e.Outcome.Error != "" || e.Outcome.SQLState != "22P02" {
+		t.Errorf("outcome %+v, want the SQLSTATE without the message", e.Outcome)
+	}
+	if e.Statement.Query != "SELECT * FROM t WHERE id = $1" {
+		t.Errorf("query %q, want it normalized", e.Statement.Query)
+	}
+	if outcome.Error == "" {
+		t.Error("the outcome was modified in place")
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	ssn := config.RedactionRule{Name: "ssn", Columns: []string{"ssn"}, Action: RedactDrop}
+	card := config.RedactionRule{Name: "card", Pattern: regexp.MustCompile(`^\d{4}-\d{4}-\d{4}-\d{4}$`), Action: RedactHash}
+	secret := config.RedactionRule{Name: "secret", Columns: []string{"note"}, Pattern: regexp.MustCompile(`secret`), Action: RedactDrop}
+	cardHash := "'sha256:" + sha256Hex("4111-1111-1111-1111") + "'"
+
+	tests := []struct {
+		name  string
+		c     config.AuditRedaction
+		query string
+		want  string
+		rules []string
+	}{
+		{"column rule", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"SELECT * FROM t WHERE ssn = '123-45-6789' AND id = 1",
+			"SELECT * FROM t WHERE ssn = '[redacted]' AND id = 1", []string{"ssn"}},
+		{"column rule on a number", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"UPDATE t SET ssn = 123456789 WHERE id = 1",
+			"UPDATE t SET ssn = '[redacted]' WHERE id = 1", []string{"ssn"}},
+		{"column rule in an INSERT", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"INSERT INTO t (id, ssn) VALUES (1, '123-45-6789'), (2, '987-65-4321')",
+			"INSERT INTO t (id, ssn) VALUES (1, '[redacted]'), (2, '[redacted]')", []string{"ssn"}},
+		{"column rule on another column", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"SELECT * FROM t WHERE name = '123-45-6789'",
+			"SELECT * FROM t WHERE name = '123-45-6789'", nil},
+		{"column rule on an unknown column", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"SELECT * FROM t WHERE lower(ssn) = '123-45-6789'",
+			"SELECT * FROM t WHERE lower(ssn) = '123-45-6789'", nil},
+		{"pattern rule", config.AuditRedaction{Rules: []config.RedactionRule{card}},
+			"SELECT '4111-1111-1111-1111', 'other'",
+			"SELECT " + cardHash + ", 'other'", []string{"card"}},
+		{"pattern rule on an escaped string", config.AuditRedaction{Rules: []config.RedactionRule{card}},
+			"SELECT E'4111-1111-1111-1111'",
+			"SELECT " + cardHash, []string{"card"}},
+		{"column and pattern rule", config.AuditRedaction{Rules: []config.RedactionRule{secret}},
+			"UPDATE t SET note = 'top secret', title = 'secret' WHERE note = 'public'",
+			"UPDATE t SET note = '[redacted]', title = 'secret' WHERE note = 'public'", []string{"secret"}},
+		{"first matching rule", config.AuditRedaction{Rules: []config.RedactionRule{ssn, card}},
+			"SELECT * FROM t WHERE ssn = '4111-1111-1111-1111' OR card = '4111-1111-1111-1111'",
+			"SELECT * FROM t WHERE ssn = '[redacted]' OR card = " + cardHash, []string{"ssn", "card"}},
+		{"several statements", config.AuditRedaction{Rules: []config.RedactionRule{ssn}},
+			"SELECT 1 WHERE ssn = 'a'; SELECT 2 WHERE ssn = 'b'",
+			"SELECT 1 WHERE ssn = '[redacted]'; SELECT 2 WHERE ssn = '[redacted]'", []string{"ssn"}},
+		{"password", config.AuditRedaction{Rules: []config.RedactionRule{{Name: "pw", Columns: []string{"password"}, Action: RedactDrop}}},
+			"ALTER ROLE alice PASSWORD 's3cret'",
+			"ALTER ROLE alice PASSWORD '[redacted]'", []string{"pw"}},
+		{"normalized", config.AuditRedaction{Normalize: true, Rules: []config.RedactionRule{ssn}},
+			"SELECT * FROM t WHERE ssn = '123-45-6789' AND id = 1",
+			"SELECT * FROM t WHERE ssn = $1 AND id = $2", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.Params = RedactKeep
+			r := NewRedactor(tt.c)
+			e := &Event{Type: EventStatement, Statement: &Statement{Message: "SimpleQuery", Query: tt.query}}
+			r.Redact(e)
+
+			if e.Statement.Query != tt.want {
+				t.Errorf("query %q, want %q", e.Statement.Query, tt.want)
+			}
+			var rules []string
+			if red := e.Statement.Redaction; red != nil {
+				rules = red.Rules
+				if red.Normalized != tt.c.Normalize {
+					t.Errorf("normalized %v, want %v", red.Normalized, tt.c.Normalize)
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.rules) {
+				t.Errorf("rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+// Column rules apply to the values bound to the parameters of a prepared
+// statement, for as long as it exists in its session.
+func TestRedactBoundParams(t *testing.T) {
+	ssn := config.RedactionRule{Name: "ssn", Columns: []string{"ssn"}, Action: RedactDrop}
+	r := NewRedactor(config.AuditRedaction{Params: RedactKeep, Rules: []config.RedactionRule{ssn}})
+
+	redact := func(session string, s *Statement) *Statement {
+		e := &Event{Type: EventStatement, Session: &Session{ID: session}, Statement: s}
+		r.Redact(e)
+		return e.Statement
+	}
+	bind := func(session, name string) []Arg {
+		return redact(session, &Statement{
+			Message:           "Bind",
+			PreparedStatement: name,
+			Args:              []Arg{{Format: "text", Value: "7"}, {Format: "text", Value: "123-45-6789"}, {Format: "null"}},
+		}).Args
+	}
+	redacted := []Arg{{Format: "text", Value: "7"}, {Format: "text", Value: "", Redacted: RedactDrop}, {Format: "null"}}
+	kept := []Arg{{Format: "text", Value: "7"}, {Format: "text", Value: "123-45-6789"}, {Format: "null"}}
+
+	redact("a", &Statement{Message: "Parse", PreparedStatement: "s1", Query: "SELECT * FROM t WHERE id = $1 AND ssn = $2 AND x = $3"})
+	if args := bind("a", "s1"); !reflect.DeepEqual(args, redacted) {
+		t.Errorf("bind: got %+v, want %+v", args, redacted)
+	}
+	if args := bind("b", "s1"); !reflect.DeepEqual(args, kept) {
+		t.Errorf("bind in another session: got %+v, want %+v", args, kept)
+	}
+	if args := bind("a", "s2"); !reflect.DeepEqual(args, kept) {
+		t.Errorf("bind of another statement: got %+v, want %+v", args, kept)
+	}
+
+	// Re-preparing the name replaces the columns
+	redact("a", &Statement{Message: "Parse", PreparedStatement: "s1", Query: "SELECT * FROM t WHERE id = $1 AND name = $2"})
+	if args := bind("a", "s1"); !reflect.DeepEqual(args, kept) {
+		t.Errorf("bind after re-preparing: got %+v, want %+v", args, kept)
+	}
+
+	redact("a", &Statement{Message: "Parse", PreparedStatement: "s1", Query: "SELECT * FROM t WHERE id = $1 AND ssn = $2"})
+	redact("a", &Statement{Message: "Close", Object: "prepared", PreparedStatement: "s1"})
+	if args := bind("a", "s1"); !reflect.DeepEqual(args, kept) {
+		t.Errorf("bind after close: got %+v, want %+v", args, kept)
+	}
+
+	redact("a", &Statement{Message: "Parse", PreparedStatement: "s1", Query: "SELECT * FROM t WHERE id = $1 AND ssn = $2"})
+	r.Redact(&Event{Type: EventSessionClosed, Session: &Session{ID: "a"}})
+	if len(r.prepared) != 0 {
+		t.Errorf("columns of closed sessions are kept: %v", r.prepared)
+	}
+}
+
+func TestRedactParams(t *testing.T) {
+	args := []Arg{{Format: "text", Value: "alice"}, {Format: "null"}}
+	tests := []struct {
+		name string
+		c    config.AuditRedaction
+		want string
+	}{
+		{"drop", config.AuditRedaction{Params: RedactDrop}, ""},
+		{"hash", config.AuditRedaction{Params: RedactHash}, "sha256:" + sha256Hex("alice")},
+		{"keyed hash", config.AuditRedaction{Params: RedactHash, HashKey: []byte("k")}, "hmac-sha256:" + hmacHex("k", "alice")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Event{Type: EventStatement, Statement: &Statement{Message: "FunctionCall", Args: args, Data: "616263"}}
+			NewRedactor(tt.c).Redact(e)
+
+			s := e.Statement
+			want := []Arg{{Format: "text", Value: tt.want, Redacted: tt.c.Params}, {Format: "null"}}
+			if !reflect.DeepEqual(s.Args, want) {
+				t.Errorf("args %+v, want %+v", s.Args, want)
+			}
+			if s.Redaction == nil || s.Redaction.Params != tt.c.Params {
+				t.Errorf("redaction %+v, want params %s", s.Redaction, tt.c.Params)
+			}
+			if args[0].Value != "alice" {
+				t.Error("the arguments were modified in place")
+			}
+		})
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacHex(key, s string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filter selects audit events. Zero fields match every event.
+type Filter struct {
+	// Events at or after From, and before To
+	From time.Time
+	To   time.Time
+	Type string
+	// Matched against the session, or the statistics of stats.summary
+	// events
+	User    string
+	Target  string
+	Session string
+	// Statement class, e.g. DDL
+	Class    string
+	SQLState string
+	// Matched against the query of statements and statistics
+	Query *regexp.Regexp
+	// Relation referenced by statements, optionally qualified with its
+	// schema, and how it is accessed: "read", "write", or empty for either
+	Relation string
+	Access   string
+}
+
+// Match reports whether e passes the filter.
+func (f *Filter) Match(e *Event) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+
+	var user, target, session, query string
+	if e.Session != nil {
+		user, target, session = e.Session.User, e.Session.Target, e.Session.ID
+	}
+	if e.Stats != nil {
+		user, target, query = e.Stats.User, e.Stats.Target, e.Stats.Query
+	}
+	if e.Statement != nil {
+		query = e.Statement.Query
+	}
+	if (f.User != "" && user != f.User) ||
+		(f.Target != "" && target != f.Target) ||
+		(f.Session != "" && session != f.Session) {
+		return false
+	}
+
+	if f.Class != "" && !f.hasClass(e) {
+		return false
+	}
+	if f.SQLState != "" && (e.Outcome == nil || !strings.EqualFold(e.Outcome.SQLState, f.SQLState)) {
+		return false
+	}
+	if f.Query != nil && (query == "" || !f.Query.MatchString(query)) {
+		return false
+	}
+	if f.Relation != "" && !f.hasRelation(e) {
+		return false
+	}
+	return true
+}
+
+// hasRelation reports whether e references the filter's relation. A
+// reference without a schema matches whatever the schema given, since the
+// session's search_path is unknown.
+func (f *Filter) hasRelation(e *Event) bool {
+	if e.Statement == nil {
+		return false
+	}
+	schema, name := "", f.Relation
+	if i := strings.LastIndexByte(f.Relation, '.'); i >= 0 {
+		schema, name = f.Relation[:i], f.Relation[i+1:]
+	}
+	for _, r := range e.Statement.Relations {
+		if !strings.EqualFold(r.Name, name) ||
+			(schema != "" && r.Schema != "" && !strings.EqualFold(r.Schema, schema)) ||
+			(f.Access != "" && r.Access != f.Access) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (f *Filter) hasClass(e *Event) bool {
+	if e.Statement == nil {
+		return false
+	}
+	for _, c := range e.Statement.Classes {
+		if strings.EqualFold(c, f.Class) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search reads the JSON audit records in r and calls fn with each event
+// matching f, along with its record as written. Lines that aren't JSON
+// events, e.g. records in another format, are counted and skipped.
+func Search(r io.Reader, f *Filter, fn func(e *Event, line []byte) error) (skipped int, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Event
+			if jerr := json.Unmarshal(line, &e); jerr != nil || e.Type == "" {
+				skipped++
+			} else if f.Match(&e) {
+				if err := fn(&e, line); err != nil {
+					return skipped, err
+				}
+			}
+		}
+		if err == io.EOF {
+			return skipped, nil
+		}
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Extension of the file holding a segment's signature.
+const SignatureExt = ".sig"
+
+// SegmentManifest describes a closed segment of an audit file. Its JSON
+// encoding is what gets signed.
+type SegmentManifest struct {
+	Version        int       `json:"version"`
+	File           string    `json:"file"`
+	Size           int64     `json:"size"`
+	SHA256         string    `json:"sha256"`
+	Records        int       `json:"records"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	KeyFingerprint string    `json:"keyFingerprint"`
+}
+
+// SegmentSignature is the content of a segment's sidecar file.
+type SegmentSignature struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	PublicKey string          `json:"publicKey"`
+	Signature string          `json:"signature"`
+}
+
+// signSegment writes the sidecar for the segment at path.
+func signSegment(path string, key ed25519.PrivateKey, records int, from, to time.Time) error {
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	manifest, err := json.Marshal(SegmentManifest{
+		Version:        1,
+		File:           filepath.Base(path),
+		Size:           size,
+		SHA256:         sum,
+		Records:        records,
+		From:           from,
+		To:             to,
+		KeyFingerprint: KeyFingerprint(pub),
+	})
+	if err != nil {
+		return err
+	}
+
+	sig, err := json.Marshal(SegmentSignature{
+		Manifest:  manifest,
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest)),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+SignatureExt, append(sig, '\n'), 0600)
+}
+
+// VerifySegment checks the segment at path against its sidecar. If trusted
+// is nil, the public key embedded in the sidecar is used, which only proves
+// the segment is intact if its fingerprint is checked separately. The
+// segment may have been compressed since it was signed.
+func VerifySegment(path string, trusted ed25519.PublicKey) (*SegmentManifest, error) {
+	signed := trimCompressExt(path)
+	data, err := os.ReadFile(signed + SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	var sig SegmentSignature
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, fmt.Errorf("Malformed signature file: %w", err)
+	}
+	var m SegmentManifest
+	if err := json.Unmarshal(sig.Manifest, &m); err != nil {
+		return nil, fmt.Errorf("Malformed segment manifest: %w", err)
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(sig.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("Malformed public key in signature file")
+	}
+	if KeyFingerprint(pub) != m.KeyFingerprint {
+		return nil, errors.New("Public key does not match the manifest's fingerprint")
+	}
+	if trusted != nil && !trusted.Equal(ed25519.PublicKey(pub)) {
+		return nil, fmt.Errorf("Signed by key %s, not the trusted key %s", m.KeyFingerprint, KeyFingerprint(trusted))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, sig.Manifest, signature) {
+		return nil, errors.New("Invalid signature")
+	}
+
+	if m.File != filepath.Base(signed) {
+		return nil, fmt.Errorf("Signature belongs to segment %s", m.File)
+	}
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if size != m.Size || sum != m.SHA256 {
+		return nil, errors.New("Segment contents were modified")
+	}
+	return &m, nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := OpenFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,287 @@
+package audit
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// ErrBufferFull is returned by sinks that deliver asynchronously when their
+// buffer has no room for another event.
+var ErrBufferFull = errors.New("Audit buffer full")
+
+const (
+	defaultBufferSize = 10000
+	dialTimeout       = 10 * time.Second
+	minRetryDelay     = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+	// How long Close keeps trying to deliver buffered events
+	flushTimeout = 5 * time.Second
+)
+
+// Structured data ID of the event parameters. 32473 is the private
+// enterprise number reserved for documentation (RFC 5612).
+const syslogSDID = "mammoth@32473"
+
+// SyslogOptions configures a SyslogSink.
+type SyslogOptions struct {
+	// "udp", "tcp" or "tls"
+	Network   string
+	Address   string
+	TLSConfig *tls.Config
+	Facility  int
+	AppName   string
+	// Number of events held while the server is unreachable
+	BufferSize int
+}
+
+// SyslogSink sends events as RFC 5424 messages. Messages are sent in the
+// background, reconnecting as needed; over TCP they are framed by octet
+// counting (RFC 6587).
+type SyslogSink struct {
+	opts     SyslogOptions
+	f        Formatter
+	hostname string
+	procID   string
+
+	queue chan *Event
+	stop  chan struct{}
+	done  chan struct{}
+	conn  net.Conn
+	// Set while the server is unreachable
+	failing atomic.Bool
+	// Set once Close gives up on the server
+	giveUp  time.Time
+	dropped int
+}
+
+func NewSyslogSink(f Formatter, opts SyslogOptions) *SyslogSink {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.AppName == "" {
+		opts.AppName = "mammoth"
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &SyslogSink{
+		opts:     opts,
+		f:        f,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		queue:    make(chan *Event, opts.BufferSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues e for delivery.
+func (s *SyslogSink) Write(e *Event) error {
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Check reports whether the server is reachable and the buffer has room.
+func (s *SyslogSink) Check() error {
+	if len(s.queue) >= cap(s.queue) {
+		return ErrBufferFull
+	}
+	if s.failing.Load() {
+		return fmt.Errorf("Syslog server %s unreachable", s.opts.Address)
+	}
+	return nil
+}
+
+func (s *SyslogSink) run() {
+	defer close(s.done)
+	for {
+		select {
+		case e := <-s.queue:
+			s.deliver(e)
+		case <-s.stop:
+			s.giveUp = time.Now().Add(flushTimeout)
+			for {
+				select {
+				case e := <-s.queue:
+					s.deliver(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends e, retrying until it succeeds or the sink is closed and
+// out of time.
+func (s *SyslogSink) deliver(e *Event) {
+	msg, err := s.message(e)
+	if err != nil {
+		log.Errorf("Error formatting audit event for syslog: %v", err)
+		return
+	}
+
+	delay := minRetryDelay
+	for {
+		if !s.giveUp.IsZero() && time.Now().After(s.giveUp) {
+			s.dropped++
+			return
+		}
+
+		err := s.send(msg)
+		if err == nil {
+			if s.failing.Swap(false) {
+				log.Infof("Audit syslog server %s reachable again", s.opts.Address)
+			}
+			return
+		}
+		if !s.failing.Swap(true) {
+			log.Errorf("Error sending audit event to syslog server %s: %v", s.opts.Address, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			// Keep trying until the flush timeout
+			if s.giveUp.IsZero() {
+				s.giveUp = time.Now().Add(flushTimeout)
+			}
+			time.Sleep(minRetryDelay)
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (s *SyslogSink) send(msg []byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	if s.opts.Network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	if _, err := s.conn.Write(msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch s.opts.Network {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", s.opts.Address, s.opts.TLSConfig)
+	case "udp", "tcp":
+		return dialer.Dial(s.opts.Network, s.opts.Address)
+	}
+	return nil, fmt.Errorf("Unknown syslog network: %s", s.opts.Network)
+}
+
+// message builds the RFC 5424 message for e:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func (s *SyslogSink) message(e *Event) ([]byte, error) {
+	body, err := s.f.Format(e)
+	if err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %s %s %s ",
+		s.opts.Facility*8+Level(e),
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogName(s.hostname, 255),
+		syslogName(s.opts.AppName, 48),
+		s.procID,
+		syslogName(e.Type, 32),
+		structuredData(e),
+	)
+	return append([]byte(header), body...), nil
+}
+
+// syslogName makes s fit a header field: printable ASCII, no spaces.
+func syslogName(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > ' ' && s[i] < 0x7f {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData carries the fields a SIEM most likely filters on.
+func structuredData(e *Event) string {
+	var params []string
+	add := func(name, value string) {
+		if value != "" {
+			params = append(params, name+`="`+sdEscaper.Replace(value)+`"`)
+		}
+	}
+	if e.Alert != nil {
+		add("rule", e.Alert.Rule)
+		add("count", strconv.Itoa(e.Alert.Count))
+	}
+	if e.Session != nil {
+		add("session", e.Session.ID)
+		add("user", e.Session.User)
+		add("client", e.Session.Client)
+		add("server", e.Session.Server)
+		add("database", e.Session.Database)
+		add("target", e.Session.Target)
+		if e.Session.BreakGlass {
+			add("breakGlass", "true")
+		}
+	}
+	if e.Outcome != nil {
+		add("decision", e.Outcome.Decision)
+		add("reason", e.Outcome.Reason)
+		add("sqlstate", e.Outcome.SQLState)
+	}
+	if len(params) == 0 {
+		return "-"
+	}
+	return "[" + syslogSDID + " " + strings.Join(params, " ") + "]"
+}
+
+// Close delivers what is still buffered, giving up after a while.
+func (s *SyslogSink) Close() error {
+	close(s.stop)
+	<-s.done
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.dropped > 0 {
+		return fmt.Errorf("%d audit event(s) could not be delivered to syslog server %s", s.dropped, s.opts.Address)
+	}
+	return nil
+}
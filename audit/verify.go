@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Problem is a break in the hash chain found by a Verifier.
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+	// Warnings don't prove tampering, e.g. a log that ends without a stop
+	// checkpoint might just have been copied while mammoth was running
+	Warning bool
+}
+
+func (p Problem) String() string {
+	if p.Warning {
+		return fmt.Sprintf("%s:%d: warning: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Verifier checks that chained audit records are complete, in order and
+// unmodified. Files fed to it one after the other are verified as one
+// stream, so rotated logs can be checked together.
+type Verifier struct {
+	Problems []Problem
+	Records  int
+	Chains   int
+
+	file     string
+	line     int
+	lastFile string
+	lastLine int
+	inChain  bool
+	resync   bool
+	stopped  bool
+	seq      uint64
+	prevHash string
+}
+
+type chainedRecord struct {
+	Type       string      `json:"type"`
+	Checkpoint *Checkpoint `json:"checkpoint"`
+	Seq        uint64      `json:"seq"`
+	PrevHash   string      `json:"prevHash"`
+}
+
+// OK reports whether no tampering was found so far.
+func (v *Verifier) OK() bool {
+	for _, p := range v.Problems {
+		if !p.Warning {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *Verifier) problem(warning bool, format string, args ...interface{}) {
+	v.Problems = append(v.Problems, Problem{
+		File:    v.file,
+		Line:    v.line,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+// Verify reads the records of the named file from r.
+func (v *Verifier) Verify(name string, r io.Reader) error {
+	v.file, v.line = name, 0
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			v.line++
+			v.record(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (v *Verifier) record(line []byte) {
+	body, hash, ok := splitRecord(line)
+	if !ok {
+		v.problem(false, "record is not chained")
+		v.resync = true
+		return
+	}
+	var rec chainedRecord
+	if err := json.Unmarshal(body, &rec); err != nil {
+		v.problem(false, "record is not valid JSON: %v", err)
+		v.resync = true
+		return
+	}
+	v.Records++
+
+	if hashRecord(body) != hash {
+		v.problem(false, "record %d was modified (hash mismatch)", rec.Seq)
+	}
+
+	start := rec.Type == EventCheckpoint && rec.Checkpoint != nil && rec.Checkpoint.Reason == CheckpointStart
+	switch {
+	case start:
+		if rec.Seq != 1 || rec.PrevHash != "" {
+			v.problem(false, "chain start checkpoint has sequence %d and a previous hash", rec.Seq)
+		}
+		if v.inChain && !v.stopped {
+			v.problem(true, "a new chain starts, but the previous one has no stop checkpoint; mammoth crashed or records were truncated")
+		}
+		v.Chains++
+	case !v.inChain:
+		v.problem(true, "log starts mid-chain at record %d; earlier records can't be checked", rec.Seq)
+		v.Chains++
+	case v.resync:
+		// The previous line was unreadable, so it can't be linked to
+	case v.stopped:
+		v.problem(false, "record %d follows the chain's stop checkpoint", rec.Seq)
+	case rec.Seq > v.seq+1:
+		v.problem(false, "%d record(s) missing between record %d and %d", rec.Seq-v.seq-1, v.seq, rec.Seq)
+	case rec.Seq <= v.seq:
+		// Keep following the chain from the latest record, which is what
+		// the next one should link to
+		v.problem(false, "record %d is out of order, it follows record %d", rec.Seq, v.seq)
+		return
+	case rec.PrevHash != v.prevHash:
+		v.problem(false, "record %d does not link to the previous record, which was modified or replaced", rec.Seq)
+	}
+
+	v.inChain, v.resync = true, false
+	v.lastFile, v.lastLine = v.file, v.line
+	v.stopped = rec.Type == EventCheckpoint && rec.Checkpoint != nil && rec.Checkpoint.Reason == CheckpointStop
+	v.seq, v.prevHash = rec.Seq, hash
+}
+
+// Finish checks that the last chain was closed properly.
+func (v *Verifier) Finish() {
+	if v.inChain && !v.stopped {
+		v.file, v.line = v.lastFile, v.lastLine
+		v.problem(true, "log ends without a stop checkpoint; mammoth is still running, crashed, or records were truncated")
+	}
+}
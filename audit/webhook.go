@@ -0,0 +1,301 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultMaxRetries    = 5
+	defaultHTTPTimeout   = 10 * time.Second
+)
+
+// WebhookOptions configures a WebhookSink.
+type WebhookOptions struct {
+	URL       string
+	Headers   map[string]string
+	TLSConfig *tls.Config
+	// Key used to sign requests with HMAC-SHA256, if any
+	Secret []byte
+	// A batch is sent once it holds BatchSize events, or FlushInterval
+	// after the previous one at the latest
+	BatchSize     int
+	FlushInterval time.Duration
+	// Retries of a failed request before its batch is given up on
+	MaxRetries int
+	Timeout    time.Duration
+	// File that batches are appended to when given up on
+	DeadLetter string
+	// Number of events held while the endpoint is unreachable
+	BufferSize int
+}
+
+// WebhookSink POSTs events to an HTTP endpoint in batches: a JSON array with
+// JSON-based formats, one record per line otherwise. Requests are sent in
+// the background and retried with exponential back-off.
+type WebhookSink struct {
+	opts   WebhookOptions
+	f      Formatter
+	client *http.Client
+
+	queue chan *Event
+	stop  chan struct{}
+	done  chan struct{}
+	// Set once Close gives up on the endpoint
+	giveUp time.Time
+	// Set while the endpoint is unreachable
+	failing atomic.Bool
+	dropped int
+}
+
+func NewWebhookSink(f Formatter, opts WebhookOptions) *WebhookSink {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHTTPTimeout
+	}
+
+	s := &WebhookSink{
+		opts: opts,
+		f:    f,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: opts.TLSConfig,
+			},
+		},
+		queue: make(chan *Event, opts.BufferSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues e for delivery.
+func (s *WebhookSink) Write(e *Event) error {
+	select {
+	case s.queue <- e:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Check reports whether the endpoint is reachable and the buffer has room.
+func (s *WebhookSink) Check() error {
+	if len(s.queue) >= cap(s.queue) {
+		return ErrBufferFull
+	}
+	if s.failing.Load() {
+		return fmt.Errorf("Webhook %s unreachable", s.opts.URL)
+	}
+	return nil
+}
+
+func (s *WebhookSink) run() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.opts.FlushInterval)
+	defer t.Stop()
+
+	var batch [][]byte
+	add := func(e *Event) {
+		record, err := s.f.Format(e)
+		if err != nil {
+			log.Errorf("Error formatting audit event for webhook: %v", err)
+			return
+		}
+		batch = append(batch, record)
+		if len(batch) >= s.opts.BatchSize {
+			s.deliver(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case e := <-s.queue:
+			add(e)
+		case <-t.C:
+			if len(batch) > 0 {
+				s.deliver(batch)
+				batch = nil
+			}
+		case <-s.stop:
+			s.giveUp = time.Now().Add(flushTimeout)
+			for len(s.queue) > 0 {
+				add(<-s.queue)
+			}
+			if len(batch) > 0 {
+				s.deliver(batch)
+			}
+			return
+		}
+	}
+}
+
+// deliver sends batch, retrying until it succeeds or the batch is given up
+// on and written to the dead-letter file.
+func (s *WebhookSink) deliver(batch [][]byte) {
+	var body []byte
+	if s.f.ContentType() == "application/json" {
+		body = append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+		body = append(body, ']')
+	} else {
+		body = append(bytes.Join(batch, []byte{'\n'}), '\n')
+	}
+	// Stays the same across retries, so the endpoint can discard duplicates
+	id := NewSessionID()
+
+	delay := minRetryDelay
+	for attempt := 0; ; attempt++ {
+		if !s.giveUp.IsZero() && time.Now().After(s.giveUp) {
+			s.deadLetter(batch, fmt.Errorf("Gave up on webhook %s during shutdown", s.opts.URL))
+			return
+		}
+
+		retry, err := s.post(id, body)
+		if err == nil {
+			if s.failing.Swap(false) {
+				log.Infof("Audit webhook %s reachable again", s.opts.URL)
+			}
+			return
+		}
+		if !retry || attempt >= s.opts.MaxRetries {
+			s.deadLetter(batch, err)
+			return
+		}
+		if !s.failing.Swap(true) {
+			log.Errorf("Error sending audit events to webhook %s: %v", s.opts.URL, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			// Keep trying until the flush timeout
+			time.Sleep(minRetryDelay)
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// post sends one request, and reports whether a failed request is worth
+// retrying.
+func (s *WebhookSink) post(id string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", s.f.ContentType())
+	req.Header.Set("X-Mammoth-Batch", id)
+	if len(s.opts.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Mammoth-Timestamp", timestamp)
+		req.Header.Set("X-Mammoth-Signature", "sha256="+signRequest(s.opts.Secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("Webhook returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("Webhook rejected events: %s", resp.Status)
+}
+
+// signRequest returns the hex HMAC-SHA256 of the timestamp and body joined
+// by a dot. Covering the timestamp lets the endpoint refuse replays.
+func signRequest(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter appends the records of a batch that couldn't be delivered to
+// the dead-letter file, one per line.
+func (s *WebhookSink) deadLetter(batch [][]byte, cause error) {
+	if s.opts.DeadLetter == "" {
+		log.Errorf("Dropped %d audit event(s) for webhook %s: %v", len(batch), s.opts.URL, cause)
+		s.dropped += len(batch)
+		return
+	}
+
+	err := appendLines(s.opts.DeadLetter, batch)
+	if err != nil {
+		log.Errorf("Dropped %d audit event(s) for webhook %s: %v; error writing dead-letter file: %v", len(batch), s.opts.URL, cause, err)
+		s.dropped += len(batch)
+		return
+	}
+	log.Errorf("Wrote %d audit event(s) for webhook %s to dead-letter file %s: %v", len(batch), s.opts.URL, s.opts.DeadLetter, cause)
+}
+
+func appendLines(path string, lines [][]byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	buf := bytes.Buffer{}
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close delivers what is still buffered, giving up after a while.
+func (s *WebhookSink) Close() error {
+	close(s.stop)
+	<-s.done
+	s.client.CloseIdleConnections()
+	if s.dropped > 0 {
+		return fmt.Errorf("%d audit event(s) could not be delivered to webhook %s", s.dropped, s.opts.URL)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterSink writes formatted events to an io.Writer, one per line.
+type WriterSink struct {
+	mtx sync.Mutex
+	w   io.Writer
+	f   Formatter
+	c   io.Closer
+}
+
+// NewStdoutSink writes events to the process' standard output.
+func NewStdoutSink(f Formatter) *WriterSink {
+	return &WriterSink{w: os.Stdout, f: f}
+}
+
+func (s *WriterSink) Write(e *Event) error {
+	line, err := s.f.Format(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, err = s.w.Write(line)
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
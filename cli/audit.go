@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/spf13/cobra"
+)
+
+var trustedKeyPath string
+var trustedFingerprint string
+
+func init() {
+	auditVerifySegmentsCmd.Flags().StringVarP(&trustedKeyPath, "key", "k", "", "PEM file with the public key segments must be signed with")
+	auditVerifySegmentsCmd.Flags().StringVarP(&trustedFingerprint, "fingerprint", "", "", "fingerprint of the key segments must be signed with")
+
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditVerifySegmentsCmd)
+	mainCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Work with audit logs",
+}
+
+var auditVerifySegmentsCmd = &cobra.Command{
+	Use:          "verify-segments <segment>...",
+	Short:        "Verify the signatures of audit log segments",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runAuditVerifySegments,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:          "verify <file>...",
+	Short:        "Verify the hash chain of audit log files, given oldest first",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runAuditVerify,
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) error {
+	v := &audit.Verifier{}
+	for _, name := range args {
+		f, err := audit.OpenFile(name)
+		if err != nil {
+			return err
+		}
+		err = v.Verify(name, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", name, err)
+		}
+	}
+	v.Finish()
+
+	for _, p := range v.Problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d records in %d chain(s), %d problem(s)\n", v.Records, v.Chains, len(v.Problems))
+
+	if !v.OK() {
+		return errors.New("Audit log verification failed")
+	}
+	return nil
+}
+
+func runAuditVerifySegments(cmd *cobra.Command, args []string) error {
+	var trusted ed25519.PublicKey
+	if trustedKeyPath != "" {
+		var err error
+		if trusted, err = audit.LoadPublicKey(trustedKeyPath); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	keys := map[string]bool{}
+	var segments []string
+	for _, name := range args {
+		// So that globs may match the sidecars too
+		if !strings.HasSuffix(name, audit.SignatureExt) {
+			segments = append(segments, name)
+		}
+	}
+
+	for _, name := range segments {
+		m, err := audit.VerifySegment(name, trusted)
+		if err == nil && trustedFingerprint != "" && !strings.EqualFold(m.KeyFingerprint, trustedFingerprint) {
+			err = fmt.Errorf("Signed by key %s, not %s", m.KeyFingerprint, trustedFingerprint)
+		}
+		if err != nil {
+			fmt.Printf("%s: FAILED: %v\n", name, err)
+			failed++
+			continue
+		}
+		keys[m.KeyFingerprint] = true
+		fmt.Printf("%s: OK, %d records from %s to %s\n", name, m.Records,
+			m.From.Format(time.RFC3339), m.To.Format(time.RFC3339))
+	}
+
+	if trusted == nil && trustedFingerprint == "" {
+		for k := range keys {
+			fmt.Printf("Signed by key %s; check it against the instance's known fingerprint, or pass --key\n", k)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d segment(s) failed verification", failed, len(segments))
+	}
+	return nil
+}
@@ -17,7 +17,10 @@ package cli
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/config"
 	"github.com/brunopadz/mammoth/config/file"
 	"github.com/brunopadz/mammoth/server"
@@ -60,7 +63,31 @@ func runStart(cmd *cobra.Command, args []string) {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
-	s := server.NewServer(c)
+	a, err := audit.Open(c.Audit)
+	if err != nil {
+		log.Fatalf("Error opening audit sinks: %v", err)
+	}
+	defer a.Close()
+
+	s := server.NewServer(c, a)
+
+	// Stop cleanly, so that the audit chain is closed with a checkpoint.
+	// SIGHUP starts new audit files.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGHUP {
+				if err := a.Reopen(); err != nil {
+					log.Errorf("%v", err)
+				}
+				continue
+			}
+			log.Infof("Received %v, shutting down", sig)
+			s.Stop()
+			return
+		}
+	}()
 
 	s.Start()
 
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/record"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/cobra"
+)
+
+const replayConnectTimeout = 30 * time.Second
+
+var replayTarget string
+var replayUser string
+var replaySpeed float64
+
+func init() {
+	replayCmd.Flags().StringVarP(&replayTarget, "target", "t", "", "backend to replay against, as host[:port][/database]")
+	replayCmd.Flags().StringVarP(&replayUser, "user", "U", "", "user to connect as (default the recorded user)")
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "s", 1, "speed factor, 0 to replay without delays")
+	replayCmd.MarkFlagRequired("target")
+
+	mainCmd.AddCommand(replayCmd)
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <recording>",
+	Short: "Replay a recorded session against a backend",
+	Long: `Replay a recorded session against a backend, sending the messages the
+client sent with the recorded timing. Statements mammoth rejected when
+recorded are skipped, as the backend never saw them.
+
+The password and TLS settings are taken from the usual libpq environment
+variables and files, e.g. PGPASSWORD, ~/.pgpass and PGSSLMODE.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         runReplay,
+}
+
+// parseReplayTarget splits host[:port][/database].
+func parseReplayTarget(target string) (host, port, database string, err error) {
+	hostport := target
+	if i := strings.IndexByte(target, '/'); i >= 0 {
+		hostport, database = target[:i], target[i+1:]
+	}
+	host, port = hostport, "5432"
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, p
+	}
+	if host == "" {
+		return "", "", "", fmt.Errorf("Invalid target: %s", target)
+	}
+	return host, port, database, nil
+}
+
+func runReplay(cmd *cobra.Command, args []string) error {
+	if replaySpeed < 0 {
+		return errors.New("Speed must not be negative")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %w", args[0], err)
+	}
+	h := r.Header
+
+	host, port, database, err := parseReplayTarget(replayTarget)
+	if err != nil {
+		return err
+	}
+	if database == "" {
+		database = h.Database
+	}
+	user := replayUser
+	if user == "" {
+		user = h.User
+	}
+
+	fmt.Printf("Session %s by %s to %s/%s, recorded %s\n", h.Session, h.User, h.Server, h.Database, h.Start.Format(time.RFC3339))
+	fmt.Printf("Replaying as %s to %s/%s\n", user, net.JoinHostPort(host, port), database)
+
+	connString := (&url.URL{
+		Scheme: "postgres",
+		User:   url.User(user),
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/" + database,
+	}).String()
+	pgConfig, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replayConnectTimeout)
+	defer cancel()
+	pgConn, err := pgconn.ConnectConfig(ctx, pgConfig)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to backend: %w", err)
+	}
+	hijacked, err := pgConn.Hijack()
+	if err != nil {
+		return err
+	}
+	conn := hijacked.Conn
+	defer conn.Close()
+
+	// Read the backend's answers as they come, so it never blocks
+	var errorCount int
+	readDone := make(chan error, 1)
+	go func() {
+		readDone <- readReplayResponses(bufio.NewReader(conn), &errorCount)
+	}()
+
+	var messages, rejected int
+	var base time.Duration
+	first, terminated := true, false
+	start := time.Now()
+	for !terminated {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Replay what was recorded of a session cut short
+			fmt.Printf("Error reading %s, replaying up to there: %v\n", args[0], err)
+			break
+		}
+
+		if first {
+			// Time spent authenticating isn't replayed
+			base = rec.Offset
+			first = false
+		}
+		if replaySpeed > 0 {
+			due := time.Duration(float64(rec.Offset-base) / replaySpeed)
+			time.Sleep(time.Until(start.Add(due)))
+		}
+
+		m := rec.Message
+		if rec.Flags&record.FlagRejected != 0 {
+			// The backend never saw it
+			rejected++
+			continue
+		}
+		if err := m.Encode(conn); err != nil {
+			return fmt.Errorf("Error writing to backend: %w", err)
+		}
+		messages++
+		terminated = m.Type == protocol.TerminateMessageType
+	}
+	if !terminated {
+		t := protocol.NewBuffer().Message(protocol.TerminateMessageType)
+		if err := t.Encode(conn); err != nil {
+			return fmt.Errorf("Error writing to backend: %w", err)
+		}
+	}
+
+	// The backend closes the connection once it has handled Terminate
+	if err := <-readDone; err != nil {
+		return fmt.Errorf("Error reading from backend: %w", err)
+	}
+
+	fmt.Printf("Replayed %d message(s), %d skipped as rejected when recorded, in %s: %d error(s)\n",
+		messages, rejected, time.Since(start).Round(time.Millisecond), errorCount)
+	return nil
+}
+
+// readReplayResponses reads the backend's messages until it closes the
+// connection, printing and counting its errors.
+func readReplayResponses(r *bufio.Reader, errorCount *int) error {
+	for {
+		m, err := protocol.ReadTypedMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Type != protocol.ErrorMessageType {
+			continue
+		}
+		*errorCount++
+		if e, err := protocol.ReadError(m.Reader()); err == nil {
+			fmt.Printf("%s %s: %s\n", e.Severity, e.Code, e.Message)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/spf13/cobra"
+)
+
+// Output formats of audit search.
+const (
+	searchOutputTable = "table"
+	searchOutputJSON  = "json"
+	searchOutputCSV   = "csv"
+)
+
+// Longest query shown in table output.
+const searchQueryWidth = 80
+
+const searchTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+var searchFilter audit.Filter
+var searchSince string
+var searchUntil string
+var searchQuery string
+var searchRelation string
+var searchOutput string
+var searchNoRotated bool
+
+func init() {
+	flags := auditSearchCmd.Flags()
+	flags.StringVarP(&searchSince, "since", "", "", "only events at or after this time (RFC 3339, date, or duration ago, e.g. 2h)")
+	flags.StringVarP(&searchUntil, "until", "", "", "only events before this time (RFC 3339, date, or duration ago)")
+	flags.StringVarP(&searchFilter.Type, "type", "", "", "event type, e.g. statement or session.auth")
+	flags.StringVarP(&searchFilter.User, "user", "u", "", "database user")
+	flags.StringVarP(&searchFilter.Target, "target", "t", "", "target name")
+	flags.StringVarP(&searchFilter.Session, "session", "s", "", "session ID")
+	flags.StringVarP(&searchFilter.Class, "class", "", "", "statement class, e.g. DDL or WRITE")
+	flags.StringVarP(&searchFilter.SQLState, "sqlstate", "", "", "SQLSTATE of the outcome, e.g. 42501")
+	flags.StringVarP(&searchQuery, "query", "q", "", "regular expression matched against the query text")
+	flags.StringVarP(&searchRelation, "relation", "", "", "relation referenced, optionally with a schema and an access mode, e.g. payments.cards:write")
+	flags.StringVarP(&searchOutput, "output", "o", searchOutputTable, "output format: table, json or csv")
+	flags.BoolVarP(&searchNoRotated, "no-rotated", "", false, "don't search the rotated segments of each file")
+
+	auditCmd.AddCommand(auditSearchCmd)
+}
+
+var auditSearchCmd = &cobra.Command{
+	Use:   "search <file>...",
+	Short: "Search JSON audit files for events",
+	Long: `Search JSON audit files for events matching every filter given.
+
+The rotated segments of each file are searched too, oldest first and before
+the file itself, and compressed files are decompressed on the fly. JSON
+output prints the matching records as written.`,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runAuditSearch,
+}
+
+// parseSearchTime accepts an RFC 3339 time, a date, or a duration before
+// now.
+func parseSearchTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time: %s", s)
+}
+
+// parseSearchRelation splits a relation filter such as payments.cards:write
+// into the relation and the access mode.
+func parseSearchRelation(s string) (relation, access string, err error) {
+	relation = s
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		relation, access = s[:i], s[i+1:]
+		if access != "read" && access != "write" {
+			return "", "", fmt.Errorf("Invalid access mode %q, expected read or write", access)
+		}
+	}
+	if relation == "" || strings.HasSuffix(relation, ".") {
+		return "", "", fmt.Errorf("Invalid relation: %s", s)
+	}
+	return relation, access, nil
+}
+
+// searchFiles expands the files given to their rotated segments.
+func searchFiles(args []string) ([]string, error) {
+	var files []string
+	for _, name := range args {
+		var segments []string
+		if !searchNoRotated {
+			var err error
+			if segments, err = audit.Segments(name); err != nil {
+				return nil, err
+			}
+			files = append(files, segments...)
+		}
+		if _, err := os.Stat(name); err != nil {
+			if os.IsNotExist(err) && len(segments) > 0 {
+				// Rotated away with nothing written since
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+func runAuditSearch(cmd *cobra.Command, args []string) error {
+	f := searchFilter
+	now := time.Now()
+	var err error
+	if searchSince != "" {
+		if f.From, err = parseSearchTime(searchSince, now); err != nil {
+			return err
+		}
+	}
+	if searchUntil != "" {
+		if f.To, err = parseSearchTime(searchUntil, now); err != nil {
+			return err
+		}
+	}
+	if searchQuery != "" {
+		if f.Query, err = regexp.Compile(searchQuery); err != nil {
+			return fmt.Errorf("Invalid query pattern: %w", err)
+		}
+	}
+	if searchRelation != "" {
+		if f.Relation, f.Access, err = parseSearchRelation(searchRelation); err != nil {
+			return err
+		}
+	}
+
+	files, err := searchFiles(args)
+	if err != nil {
+		return err
+	}
+
+	var out searchWriter
+	switch searchOutput {
+	case searchOutputTable:
+		out = newSearchTable(os.Stdout)
+	case searchOutputJSON:
+		out = searchJSON{os.Stdout}
+	case searchOutputCSV:
+		out = newSearchCSV(os.Stdout)
+	default:
+		return fmt.Errorf("Unknown output format: %s", searchOutput)
+	}
+
+	matched, skipped := 0, 0
+	for _, name := range files {
+		r, err := audit.OpenFile(name)
+		if err != nil {
+			return err
+		}
+		n, err := audit.Search(r, &f, func(e *audit.Event, line []byte) error {
+			matched++
+			return out.Write(e, line)
+		})
+		r.Close()
+		skipped += n
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", name, err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d event(s) matched in %d file(s)\n", matched, len(files))
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d line(s) skipped, not JSON audit events\n", skipped)
+	}
+	return nil
+}
+
+// A searchWriter prints matching events.
+type searchWriter interface {
+	Write(e *audit.Event, line []byte) error
+	Flush() error
+}
+
+type searchJSON struct {
+	w io.Writer
+}
+
+func (s searchJSON) Write(e *audit.Event, line []byte) error {
+	if !strings.HasSuffix(string(line), "\n") {
+		line = append(line, '\n')
+	}
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s searchJSON) Flush() error {
+	return nil
+}
+
+type searchTable struct {
+	w *tabwriter.Writer
+}
+
+func newSearchTable(w io.Writer) *searchTable {
+	t := &searchTable{tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+	fmt.Fprintln(t.w, "TIME\tTYPE\tUSER\tTARGET\tSESSION\tOUTCOME\tDETAIL")
+	return t
+}
+
+func (t *searchTable) Write(e *audit.Event, line []byte) error {
+	f := searchFields(e)
+	outcome := f.decision
+	if f.sqlState != "" {
+		outcome += " " + f.sqlState
+	}
+	detail := f.query
+	if detail == "" {
+		detail = f.reason
+	}
+	if detail == "" {
+		detail = f.err
+	}
+	_, err := fmt.Fprintf(t.w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		e.Time.Format(searchTimeLayout), e.Type, f.user, f.target, f.session, outcome,
+		truncate(strings.Join(strings.Fields(detail), " "), searchQueryWidth))
+	return err
+}
+
+func (t *searchTable) Flush() error {
+	return t.w.Flush()
+}
+
+type searchCSV struct {
+	w *csv.Writer
+}
+
+func newSearchCSV(w io.Writer) *searchCSV {
+	c := &searchCSV{csv.NewWriter(w)}
+	c.w.Write([]string{"time", "type", "session", "client", "user", "target", "database",
+		"message", "classes", "query", "decision", "reason", "sqlstate", "error"})
+	return c
+}
+
+func (c *searchCSV) Write(e *audit.Event, line []byte) error {
+	f := searchFields(e)
+	return c.w.Write([]string{e.Time.Format(searchTimeLayout), e.Type, f.session, f.client,
+		f.user, f.target, f.database, f.message, f.classes, f.query, f.decision, f.reason,
+		f.sqlState, f.err})
+}
+
+func (c *searchCSV) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// searchRow holds the fields of an event shown in table and CSV output.
+type searchRow struct {
+	session, client, user, target, database string
+	message, classes, query                 string
+	decision, reason, sqlState, err         string
+}
+
+func searchFields(e *audit.Event) searchRow {
+	var f searchRow
+	if s := e.Session; s != nil {
+		f.session, f.client, f.user, f.target, f.database = s.ID, s.Client, s.User, s.Target, s.Database
+	}
+	if st := e.Stats; st != nil {
+		f.user, f.target, f.query = st.User, st.Target, st.Query
+	}
+	if st := e.Statement; st != nil {
+		f.message, f.classes, f.query = st.Message, strings.Join(st.Classes, " "), st.Query
+	}
+	if o := e.Outcome; o != nil {
+		f.decision, f.reason, f.sqlState, f.err = o.Decision, o.Reason, o.SQLState, o.Error
+	}
+	return f
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Don't cut a multibyte character in half
+	for n > 0 && n < len(s) && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// AccessList decides which client source addresses may connect. Deny
+// entries always win; an empty Allow list permits every address that is
+// not denied.
+type AccessList struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+func accessListFromFile(f file.AccessConfig) (AccessList, error) {
+	allow, err := parseCIDRs(f.Allow)
+	if err != nil {
+		return AccessList{}, fmt.Errorf("Invalid allow entry: %w", err)
+	}
+	deny, err := parseCIDRs(f.Deny)
+	if err != nil {
+		return AccessList{}, fmt.Errorf("Invalid deny entry: %w", err)
+	}
+	return AccessList{Allow: allow, Deny: deny}, nil
+}
+
+// Permits reports whether ip passes the access list.
+func (a AccessList) Permits(ip net.IP) bool {
+	if ip == nil {
+		return len(a.Allow) == 0 && len(a.Deny) == 0
+	}
+	if containsIP(a.Deny, ip) {
+		return false
+	}
+	return len(a.Allow) == 0 || containsIP(a.Allow, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses a list of CIDR ranges. Bare addresses are accepted and
+// treated as single-host ranges.
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", e)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// AddrIP extracts the IP address from a connection address, or nil if the
+// address does not carry one.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// IsTrustedProxy reports whether ip belongs to a load balancer or proxy
+// whose PROXY protocol header may be used to learn the real client address.
+func (c *Config) IsTrustedProxy(ip net.IP) bool {
+	return ip != nil && containsIP(c.TrustedProxies, ip)
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Alerts sent per minute at most, across every rule, unless configured.
+const defaultAlertRateLimit = 60
+
+// Alert severities.
+const (
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// Event fields alert rules can match and group on.
+const (
+	AlertFieldType     = "type"
+	AlertFieldUser     = "user"
+	AlertFieldTarget   = "target"
+	AlertFieldDatabase = "database"
+	AlertFieldClient   = "client"
+	AlertFieldSession  = "session"
+	AlertFieldMessage  = "message"
+	AlertFieldClass    = "class"
+	AlertFieldRelation = "relation"
+	AlertFieldQuery    = "query"
+	AlertFieldDecision = "decision"
+	AlertFieldReason   = "reason"
+	AlertFieldSeverity = "severity"
+	AlertFieldSQLState = "sqlstate"
+	AlertFieldError    = "error"
+	// "true" for the events of break-glass sessions
+	AlertFieldBreakGlass = "breakglass"
+)
+
+// Fields alert rules can group on, which identify who or what is involved.
+var alertGroupFields = []string{AlertFieldUser, AlertFieldTarget, AlertFieldDatabase, AlertFieldClient, AlertFieldSession}
+
+// AlertRule fires an alert once Count events matching every pattern in
+// Match happen within Window, counted separately for each combination of
+// the GroupBy fields. Further alerts for the same group are suppressed for
+// Suppress after one fires.
+type AlertRule struct {
+	Name        string
+	Description string
+	Severity    string
+	// Patterns by field, anchored at both ends
+	Match        map[string]*regexp.Regexp
+	Count        int
+	Window       time.Duration
+	GroupBy      []string
+	Suppress     time.Duration
+	Destinations []string
+}
+
+// AlertDestination is a sink alerts are sent to.
+type AlertDestination struct {
+	Name string
+	Sink AuditSink
+}
+
+// AuditAlerts holds the alert rules evaluated on audit events.
+type AuditAlerts struct {
+	Destinations []AlertDestination
+	Rules        []AlertRule
+	// Alerts per minute
+	RateLimit int
+}
+
+func alertMatchFromFile(f file.AuditAlertMatchConfig) (map[string]*regexp.Regexp, error) {
+	patterns := map[string]string{
+		AlertFieldType:       f.Type,
+		AlertFieldUser:       f.User,
+		AlertFieldTarget:     f.Target,
+		AlertFieldDatabase:   f.Database,
+		AlertFieldClient:     f.Client,
+		AlertFieldSession:    f.Session,
+		AlertFieldMessage:    f.Message,
+		AlertFieldClass:      f.Class,
+		AlertFieldRelation:   f.Relation,
+		AlertFieldQuery:      f.Query,
+		AlertFieldDecision:   f.Decision,
+		AlertFieldReason:     f.Reason,
+		AlertFieldSeverity:   f.Severity,
+		AlertFieldSQLState:   f.SQLState,
+		AlertFieldError:      f.Error,
+		AlertFieldBreakGlass: f.BreakGlass,
+	}
+
+	match := map[string]*regexp.Regexp{}
+	for field, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s pattern: %w", field, err)
+		}
+		match[field] = re
+	}
+	if len(match) == 0 {
+		return nil, errors.New("Missing match")
+	}
+	return match, nil
+}
+
+func alertRuleFromFile(f file.AuditAlertRuleConfig, destinations map[string]bool) (AlertRule, error) {
+	if f.Name == "" {
+		return AlertRule{}, errors.New("Missing name")
+	}
+	r := AlertRule{
+		Name:         f.Name,
+		Description:  f.Description,
+		Severity:     f.Severity,
+		Count:        f.Count,
+		Window:       f.Window,
+		Destinations: f.Destinations,
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = AlertSeverityWarning
+	case AlertSeverityWarning, AlertSeverityCritical:
+	default:
+		return AlertRule{}, fmt.Errorf("Unknown severity %q", r.Severity)
+	}
+
+	match, err := alertMatchFromFile(f.Match)
+	if err != nil {
+		return AlertRule{}, err
+	}
+	r.Match = match
+
+	if r.Count <= 0 {
+		r.Count = 1
+	}
+	if r.Count > 1 && r.Window <= 0 {
+		return AlertRule{}, errors.New("Missing window")
+	}
+
+	for _, g := range f.GroupBy {
+		g = strings.ToLower(g)
+		known := false
+		for _, field := range alertGroupFields {
+			known = known || field == g
+		}
+		if !known {
+			return AlertRule{}, fmt.Errorf("Unknown groupBy field %q", g)
+		}
+		r.GroupBy = append(r.GroupBy, g)
+	}
+
+	// A threshold keeps firing at most once per window by default
+	if f.Suppress != nil {
+		r.Suppress = *f.Suppress
+	} else if r.Count > 1 {
+		r.Suppress = r.Window
+	}
+
+	for _, d := range r.Destinations {
+		if !destinations[d] {
+			return AlertRule{}, fmt.Errorf("Unknown destination %q", d)
+		}
+	}
+	return r, nil
+}
+
+func alertsFromFile(f file.AuditAlertsConfig) (AuditAlerts, error) {
+	a := AuditAlerts{RateLimit: f.RateLimit}
+	if a.RateLimit < 0 {
+		return AuditAlerts{}, errors.New("Invalid alert rateLimit")
+	}
+	if a.RateLimit == 0 {
+		a.RateLimit = defaultAlertRateLimit
+	}
+
+	names := map[string]bool{}
+	for i, df := range f.Destinations {
+		if df.Name == "" {
+			return AuditAlerts{}, fmt.Errorf("Error in alert destination %d (%s): Missing name", i, df.Type)
+		}
+		if names[df.Name] {
+			return AuditAlerts{}, fmt.Errorf("Duplicate alert destination %s", df.Name)
+		}
+		names[df.Name] = true
+		s, err := auditSinkFromFile(df.AuditSinkConfig)
+		if err != nil {
+			return AuditAlerts{}, fmt.Errorf("Error in alert destination %s: %w", df.Name, err)
+		}
+		a.Destinations = append(a.Destinations, AlertDestination{Name: df.Name, Sink: s})
+	}
+
+	if len(f.Rules) > 0 && len(a.Destinations) == 0 {
+		return AuditAlerts{}, errors.New("Missing alert destinations")
+	}
+	rules := map[string]bool{}
+	for i, rf := range f.Rules {
+		r, err := alertRuleFromFile(rf, names)
+		if err != nil {
+			return AuditAlerts{}, fmt.Errorf("Error in alert rule %d (%s): %w", i, rf.Name, err)
+		}
+		if rules[r.Name] {
+			return AuditAlerts{}, fmt.Errorf("Duplicate alert rule %s", r.Name)
+		}
+		rules[r.Name] = true
+		a.Rules = append(a.Rules, r)
+	}
+	return a, nil
+}
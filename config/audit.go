@@ -0,0 +1,411 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/config/file"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// AuditSink describes one destination of audit events.
+type AuditSink struct {
+	Type   string
+	Format string
+	// Events held by sinks that deliver in the background
+	BufferSize int
+	TLS        *tls.Config
+
+	// File sinks
+	Path            string
+	SegmentInterval time.Duration
+	MaxSize         int64
+	SigningKey      string
+	Compress        string
+	Retention       time.Duration
+
+	// Syslog sinks
+	Network  string
+	Address  string
+	Facility int
+	AppName  string
+
+	// Webhook sinks
+	URL           string
+	Headers       map[string]string
+	Secret        []byte
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxRetries    int
+	Timeout       time.Duration
+	DeadLetter    string
+
+	// Kafka sinks, which also use Network and Compress
+	Brokers       []string
+	Topic         string
+	ClientID      string
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+}
+
+// AuditChain controls hash chaining of audit records. Zero checkpoint
+// settings leave the choice to the audit package.
+type AuditChain struct {
+	Enabled            bool
+	CheckpointInterval time.Duration
+	CheckpointRecords  int
+}
+
+// RedactionRule redacts values in the given columns, or matching Pattern,
+// or both if both are set.
+type RedactionRule struct {
+	Name string
+	// Lower-case column names
+	Columns []string
+	Pattern *regexp.Regexp
+	// "drop" or "hash"
+	Action string
+}
+
+// AuditRedaction controls what statement values reach audit records.
+type AuditRedaction struct {
+	Normalize bool
+	// "keep", "drop" or "hash"
+	Params string
+	// Key for hashing values, if any
+	HashKey []byte
+	Rules   []RedactionRule
+}
+
+// Audit levels of statement classes.
+const (
+	// Every message, with bound parameters and COPY data
+	AuditLogFull = "full"
+	// Only the query text, and function calls without their arguments
+	AuditLogStatement = "statement"
+	AuditLogNone      = "none"
+)
+
+// AuditClass sets how statements of one class are audited.
+type AuditClass struct {
+	// AuditLogFull, AuditLogStatement or AuditLogNone
+	Log string
+	// Marks the events with the alert severity
+	Alert bool
+}
+
+// Audit holds the audit pipeline settings.
+type Audit struct {
+	Sinks     []AuditSink
+	Chain     AuditChain
+	Redaction AuditRedaction
+	// By upper-case class name, see query.Classes
+	Classes map[string]AuditClass
+	Alerts  AuditAlerts
+}
+
+// Class returns the settings for statements of the given class. Classes
+// that aren't configured are audited in full.
+func (a Audit) Class(name string) AuditClass {
+	if c, ok := a.Classes[name]; ok {
+		return c
+	}
+	return AuditClass{Log: AuditLogFull}
+}
+
+func auditClassesFromFile(f map[string]file.AuditClassConfig) (map[string]AuditClass, error) {
+	classes := map[string]AuditClass{}
+	for name, cf := range f {
+		name = strings.ToUpper(name)
+		known := false
+		for _, c := range query.Classes {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown statement class: %s", name)
+		}
+
+		c := AuditClass{Log: cf.Log, Alert: cf.Alert}
+		switch c.Log {
+		case "":
+			c.Log = AuditLogFull
+		case AuditLogFull, AuditLogStatement, AuditLogNone:
+		default:
+			return nil, fmt.Errorf("Unknown audit level %q for class %s", c.Log, name)
+		}
+		classes[name] = c
+	}
+	return classes, nil
+}
+
+// Syslog facilities by name.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func auditTLSFromFile(f file.AuditTLSConfig) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName:         f.ServerName,
+		InsecureSkipVerify: f.SkipVerify,
+	}
+	if f.Cert != "" || f.Key != "" {
+		if f.Cert == "" || f.Key == "" {
+			return nil, errors.New("Missing TLS key or cert")
+		}
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading TLS keypair: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if f.CA != "" {
+		ca, err := ioutil.ReadFile(f.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading TLS CA: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		c.RootCAs.AppendCertsFromPEM(ca)
+	}
+	return c, nil
+}
+
+func auditSinkFromFile(f file.AuditSinkConfig) (AuditSink, error) {
+	tlsConfig, err := auditTLSFromFile(f.TLS)
+	if err != nil {
+		return AuditSink{}, err
+	}
+	s := AuditSink{
+		Type:       f.Type,
+		Format:     f.Format,
+		BufferSize: f.BufferSize,
+		TLS:        tlsConfig,
+	}
+
+	switch s.Type {
+	case "stdout":
+	case "file":
+		if f.Path == "" {
+			return AuditSink{}, errors.New("Missing path")
+		}
+		maxSize, err := ParseSize(f.MaxSize)
+		if err != nil {
+			return AuditSink{}, fmt.Errorf("Invalid maxSize: %w", err)
+		}
+		switch f.Compress {
+		case "", "gzip", "zstd":
+		default:
+			return AuditSink{}, fmt.Errorf("Unknown compression %q", f.Compress)
+		}
+
+		s.Path = f.Path
+		s.SegmentInterval = f.SegmentInterval
+		s.MaxSize = maxSize
+		s.SigningKey = f.SigningKey
+		s.Compress = f.Compress
+		s.Retention = f.Retention
+	case "syslog":
+		if f.Address == "" {
+			return AuditSink{}, errors.New("Missing address")
+		}
+		switch f.Network {
+		case "":
+			f.Network = "udp"
+		case "udp", "tcp", "tls":
+		default:
+			return AuditSink{}, fmt.Errorf("Unknown network %q", f.Network)
+		}
+		if f.Facility == "" {
+			f.Facility = "local0"
+		}
+		facility, ok := syslogFacilities[f.Facility]
+		if !ok {
+			return AuditSink{}, fmt.Errorf("Unknown facility %q", f.Facility)
+		}
+
+		s.Network = f.Network
+		s.Address = f.Address
+		s.Facility = facility
+		s.AppName = f.AppName
+	case "webhook":
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return AuditSink{}, fmt.Errorf("Invalid url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return AuditSink{}, errors.New("Missing http or https url")
+		}
+		if f.SecretFile != "" {
+			secret, err := ioutil.ReadFile(f.SecretFile)
+			if err != nil {
+				return AuditSink{}, fmt.Errorf("Error loading secret: %w", err)
+			}
+			s.Secret = bytes.TrimSpace(secret)
+			if len(s.Secret) == 0 {
+				return AuditSink{}, errors.New("Empty secret file")
+			}
+		}
+
+		s.URL = f.URL
+		s.Headers = f.Headers
+		s.BatchSize = f.BatchSize
+		s.FlushInterval = f.FlushInterval
+		s.MaxRetries = f.MaxRetries
+		s.Timeout = f.Timeout
+		s.DeadLetter = f.DeadLetter
+	case "kafka":
+		if len(f.Brokers) == 0 {
+			return AuditSink{}, errors.New("Missing brokers")
+		}
+		if f.Topic == "" {
+			return AuditSink{}, errors.New("Missing topic")
+		}
+		switch f.Network {
+		case "", "tcp", "tls":
+		default:
+			return AuditSink{}, fmt.Errorf("Unknown network %q", f.Network)
+		}
+		switch f.Compress {
+		case "", "none", "gzip", "snappy", "lz4", "zstd":
+		default:
+			return AuditSink{}, fmt.Errorf("Unknown compression %q", f.Compress)
+		}
+		switch strings.ToUpper(f.SASL.Mechanism) {
+		case "":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if f.SASL.Username == "" || f.SASL.PasswordFile == "" {
+				return AuditSink{}, errors.New("Missing SASL username or passwordFile")
+			}
+			password, err := ioutil.ReadFile(f.SASL.PasswordFile)
+			if err != nil {
+				return AuditSink{}, fmt.Errorf("Error loading SASL password: %w", err)
+			}
+			s.SASLPassword = string(bytes.TrimSpace(password))
+		default:
+			return AuditSink{}, fmt.Errorf("Unknown SASL mechanism %q", f.SASL.Mechanism)
+		}
+
+		s.Network = f.Network
+		s.Compress = f.Compress
+		s.Brokers = f.Brokers
+		s.Topic = f.Topic
+		s.ClientID = f.ClientID
+		s.SASLMechanism = f.SASL.Mechanism
+		s.SASLUsername = f.SASL.Username
+	default:
+		return AuditSink{}, fmt.Errorf("Unknown type %q", s.Type)
+	}
+	return s, nil
+}
+
+func redactionRuleFromFile(f file.AuditRedactionRuleConfig) (RedactionRule, error) {
+	r := RedactionRule{
+		Name:   f.Name,
+		Action: f.Action,
+	}
+	if len(f.Columns) == 0 && f.Pattern == "" {
+		return RedactionRule{}, errors.New("Missing columns or pattern")
+	}
+	for _, c := range f.Columns {
+		r.Columns = append(r.Columns, strings.ToLower(c))
+	}
+	if f.Pattern != "" {
+		pattern, err := regexp.Compile(f.Pattern)
+		if err != nil {
+			return RedactionRule{}, fmt.Errorf("Invalid pattern: %w", err)
+		}
+		r.Pattern = pattern
+	}
+	switch r.Action {
+	case "":
+		r.Action = "drop"
+	case "drop", "hash":
+	default:
+		return RedactionRule{}, fmt.Errorf("Unknown action %q", r.Action)
+	}
+	if r.Name == "" {
+		if len(r.Columns) > 0 {
+			r.Name = strings.Join(r.Columns, ",")
+		} else {
+			r.Name = f.Pattern
+		}
+	}
+	return r, nil
+}
+
+func redactionFromFile(f file.AuditRedactionConfig) (AuditRedaction, error) {
+	r := AuditRedaction{
+		Normalize: f.Normalize,
+		Params:    f.Params,
+	}
+	switch r.Params {
+	case "":
+		r.Params = "keep"
+	case "keep", "drop", "hash":
+	default:
+		return AuditRedaction{}, fmt.Errorf("Unknown params mode %q", r.Params)
+	}
+	if f.HashKeyFile != "" {
+		key, err := ioutil.ReadFile(f.HashKeyFile)
+		if err != nil {
+			return AuditRedaction{}, fmt.Errorf("Error loading hash key: %w", err)
+		}
+		r.HashKey = bytes.TrimSpace(key)
+		if len(r.HashKey) == 0 {
+			return AuditRedaction{}, errors.New("Empty hash key file")
+		}
+	}
+	for i, rf := range f.Rules {
+		rule, err := redactionRuleFromFile(rf)
+		if err != nil {
+			return AuditRedaction{}, fmt.Errorf("Error in redaction rule %d (%s): %w", i, rf.Name, err)
+		}
+		r.Rules = append(r.Rules, rule)
+	}
+	return r, nil
+}
+
+func auditFromFile(f file.AuditConfig) (Audit, error) {
+	a := Audit{
+		Chain: AuditChain{
+			Enabled:            f.Chain.Enabled,
+			CheckpointInterval: f.Chain.CheckpointInterval,
+			CheckpointRecords:  f.Chain.CheckpointRecords,
+		},
+	}
+	redaction, err := redactionFromFile(f.Redaction)
+	if err != nil {
+		return Audit{}, err
+	}
+	a.Redaction = redaction
+
+	a.Classes, err = auditClassesFromFile(f.Classes)
+	if err != nil {
+		return Audit{}, err
+	}
+
+	a.Alerts, err = alertsFromFile(f.Alerts)
+	if err != nil {
+		return Audit{}, err
+	}
+
+	for i, sf := range f.Sinks {
+		s, err := auditSinkFromFile(sf)
+		if err != nil {
+			return Audit{}, fmt.Errorf("Error in audit sink %d (%s): %w", i, sf.Type, err)
+		}
+		a.Sinks = append(a.Sinks, s)
+	}
+	return a, nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Addresses always denied to backends, on top of the configured deny list:
+// unspecified and link-local addresses, which include cloud metadata
+// endpoints, and AWS's IPv6 metadata endpoint.
+var builtinBackendDeny = []string{
+	"0.0.0.0/8",
+	"169.254.0.0/16",
+	"::/128",
+	"fe80::/10",
+	"fd00:ec2::254/128",
+}
+
+// Loopback addresses, denied unless allowLoopback is set, so that mammoth
+// can't be pointed at services on its own host.
+var loopbackBackendDeny = []string{
+	"127.0.0.0/8",
+	"::1/128",
+}
+
+// BackendPolicy restricts which resolved addresses and ports mammoth will
+// dial. It is checked against the IPs a backend host resolves to, not the
+// name the client asked for.
+type BackendPolicy struct {
+	Addresses AccessList
+	Ports     []int
+}
+
+func backendPolicyFromFile(f file.BackendConfig) (BackendPolicy, error) {
+	deny := append([]string{}, builtinBackendDeny...)
+	if !f.AllowLoopback {
+		deny = append(deny, loopbackBackendDeny...)
+	}
+	deny = append(deny, f.Deny...)
+
+	addresses, err := accessListFromFile(file.AccessConfig{Allow: f.Allow, Deny: deny})
+	if err != nil {
+		return BackendPolicy{}, fmt.Errorf("Invalid backends entry: %w", err)
+	}
+
+	for _, port := range f.Ports {
+		if port <= 0 || port > 65535 {
+			return BackendPolicy{}, fmt.Errorf("Invalid backends port: %d", port)
+		}
+	}
+
+	return BackendPolicy{Addresses: addresses, Ports: f.Ports}, nil
+}
+
+// PermitsPort reports whether port may be dialed. An empty port list
+// permits every port.
+func (b BackendPolicy) PermitsPort(port int) bool {
+	if len(b.Ports) == 0 {
+		return true
+	}
+	for _, p := range b.Ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// PermitsIP reports whether a resolved backend address may be dialed.
+func (b BackendPolicy) PermitsIP(ip net.IP) bool {
+	return ip != nil && b.Addresses.Permits(ip)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Reasons shorter than this are refused unless configured otherwise.
+const defaultMinReasonLength = 10
+
+// Name of the alert rule, and of its destination, that sends break-glass
+// sessions to the alert webhook.
+const breakGlassAlertName = "break-glass"
+
+// BreakGlass controls emergency access: a user who would normally be
+// denied may connect by stating a reason, and the session is flagged and
+// alerted on.
+type BreakGlass struct {
+	Enabled         bool
+	Users           []string
+	MinReasonLength int
+}
+
+func breakGlassFromFile(f file.BreakGlassConfig) BreakGlass {
+	minLength := f.MinReasonLength
+	if minLength <= 0 {
+		minLength = defaultMinReasonLength
+	}
+	return BreakGlass{
+		Enabled:         f.Enabled,
+		Users:           f.Users,
+		MinReasonLength: minLength,
+	}
+}
+
+// addBreakGlassAlert adds to a the alert rule that sends the audit event of
+// every break-glass session to the alert webhook, if one is set. Alert
+// rules of their own can match those events too.
+func addBreakGlassAlert(a *AuditAlerts, f file.BreakGlassConfig) error {
+	if f.AlertWebhook == "" {
+		return nil
+	}
+	s, err := auditSinkFromFile(file.AuditSinkConfig{Type: "webhook", URL: f.AlertWebhook})
+	if err != nil {
+		return fmt.Errorf("Error in break-glass alertWebhook: %w", err)
+	}
+	for _, d := range a.Destinations {
+		if d.Name == breakGlassAlertName {
+			return fmt.Errorf("Alert destination %s is reserved for the break-glass alertWebhook", d.Name)
+		}
+	}
+	for _, r := range a.Rules {
+		if r.Name == breakGlassAlertName {
+			return fmt.Errorf("Alert rule %s is reserved for the break-glass alertWebhook", r.Name)
+		}
+	}
+
+	a.Destinations = append(a.Destinations, AlertDestination{Name: breakGlassAlertName, Sink: s})
+	a.Rules = append(a.Rules, AlertRule{
+		Name:        breakGlassAlertName,
+		Description: "Break-glass access granted",
+		Severity:    AlertSeverityCritical,
+		// The audit event of a break-glass session
+		Match:        map[string]*regexp.Regexp{AlertFieldType: regexp.MustCompile(`^session\.breakglass$`)},
+		Count:        1,
+		Destinations: []string{breakGlassAlertName},
+	})
+	return nil
+}
+
+// Permits reports whether user may use break-glass access with the given
+// reason. An empty user list lets any user break glass.
+func (b BreakGlass) Permits(user, reason string) bool {
+	if !b.Enabled || len(reason) < b.MinReasonLength {
+		return false
+	}
+	if len(b.Users) == 0 {
+		return true
+	}
+	for _, u := range b.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"regexp"
 
 	"github.com/brunopadz/mammoth/config/file"
@@ -23,10 +24,22 @@ type ServerTLSConfig struct {
 }
 
 type Config struct {
-	Bind      string
-	HostRegex *regexp.Regexp
-	Client    ClientTLSConfig
-	Server    ServerTLSConfig
+	Bind           string
+	HostRegex      *regexp.Regexp
+	Client         ClientTLSConfig
+	Server         ServerTLSConfig
+	Access         AccessList
+	TrustedProxies []*net.IPNet
+	Backends       BackendPolicy
+	Policy         Policy
+	BreakGlass     BreakGlass
+	Quotas         []*Quota
+	Denylist       Denylist
+	Audit          Audit
+	Stats          Stats
+	Admin          Admin
+	Recording      Recording
+	Targets        []*Target
 }
 
 func FromFile(f *file.Config) (*Config, error) {
@@ -40,6 +53,51 @@ func FromFile(f *file.Config) (*Config, error) {
 		}
 	}
 
+	access, err := accessListFromFile(f.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseCIDRs(f.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid trusted proxy entry: %w", err)
+	}
+
+	backends, err := backendPolicyFromFile(f.Backends)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := policyFromFile(f.Policy, Policy{})
+
+	targets, err := targetsFromFile(f.Targets, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas, err := quotasFromFile(f.Quotas)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := auditFromFile(f.Audit)
+	if err != nil {
+		return nil, err
+	}
+	if err := addBreakGlassAlert(&audit.Alerts, f.BreakGlass); err != nil {
+		return nil, err
+	}
+
+	admin, err := adminFromFile(f.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	recording, err := recordingFromFile(f.Recording, policy, targets)
+	if err != nil {
+		return nil, err
+	}
+
 	c := Config{
 		Bind:      f.Bind,
 		HostRegex: hostRegex,
@@ -50,6 +108,18 @@ func FromFile(f *file.Config) (*Config, error) {
 		Server: ServerTLSConfig{
 			AllowUnencrypted: f.Server.AllowUnencrypted,
 		},
+		Access:         access,
+		TrustedProxies: trustedProxies,
+		Backends:       backends,
+		Policy:         policy,
+		BreakGlass:     breakGlassFromFile(f.BreakGlass),
+		Quotas:         quotas,
+		Denylist:       denylistFromFile(f.Denylist),
+		Audit:          audit,
+		Stats:          statsFromFile(f.Stats),
+		Admin:          admin,
+		Recording:      recording,
+		Targets:        targets,
 	}
 
 	if f.Server.Cert != "" || f.Server.Key != "" || f.Server.CA != "" {
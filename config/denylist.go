@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Functions that reach the database host itself (its file system, other
+// servers, or the server configuration) and are always denied while the
+// denylist is enabled. A trailing * matches any suffix.
+var defaultDeniedFunctions = []string{
+	"pg_read_file",
+	"pg_read_binary_file",
+	"pg_ls_dir",
+	"pg_stat_file",
+	"lo_import",
+	"lo_export",
+	"dblink*",
+}
+
+// OIDs of the built-in pg_catalog functions above, for FunctionCall
+// messages, which refer to functions by OID only. These are fixed across
+// PostgreSQL releases; functions from extensions such as dblink get a new
+// OID per installation and have to be configured.
+var defaultDeniedFunctionOIDs = map[int32]string{
+	2624: "pg_read_file",
+	3293: "pg_read_file",
+	3826: "pg_read_file",
+	3827: "pg_read_binary_file",
+	3295: "pg_read_binary_file",
+	3828: "pg_read_binary_file",
+	2625: "pg_ls_dir",
+	3297: "pg_ls_dir",
+	2623: "pg_stat_file",
+	3307: "pg_stat_file",
+	764:  "lo_import",
+	767:  "lo_import",
+	765:  "lo_export",
+}
+
+// Denylist holds the server-side functions and commands mammoth refuses
+// to pass on. Besides the functions listed, COPY to or from a program or a
+// server file and ALTER SYSTEM are denied.
+type Denylist struct {
+	Enabled      bool
+	Functions    []string
+	FunctionOIDs map[int32]string
+}
+
+func denylistFromFile(f file.DenylistConfig) Denylist {
+	d := Denylist{
+		Enabled:      f.Enabled,
+		Functions:    append([]string{}, defaultDeniedFunctions...),
+		FunctionOIDs: make(map[int32]string),
+	}
+	for _, fn := range f.Functions {
+		d.Functions = append(d.Functions, strings.ToLower(fn))
+	}
+	for oid, name := range defaultDeniedFunctionOIDs {
+		d.FunctionOIDs[oid] = name
+	}
+	for _, oid := range f.FunctionOIDs {
+		d.FunctionOIDs[oid] = fmt.Sprintf("function with OID %d", oid)
+	}
+	return d
+}
+
+// DeniesFunction reports whether the function name (lower-case, without
+// schema) is denied.
+func (d Denylist) DeniesFunction(name string) bool {
+	for _, fn := range d.Functions {
+		if strings.HasSuffix(fn, "*") {
+			if strings.HasPrefix(name, fn[:len(fn)-1]) {
+				return true
+			}
+		} else if name == fn {
+			return true
+		}
+	}
+	return false
+}
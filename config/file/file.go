@@ -15,6 +15,8 @@ limitations under the License.
 package file
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 
 	"github.com/brunopadz/mammoth/util/log"
@@ -25,6 +27,7 @@ func init() {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 	viper.SetDefault("client.tryssl", true)
+	viper.SetDefault("denylist.enabled", true)
 }
 
 type ServerConfig struct {
@@ -43,12 +46,247 @@ type ClientConfig struct {
 	TrySSL           bool   `mapstructure:"tryssl"`
 }
 
+// AccessConfig lists the client source addresses, as CIDR ranges or bare
+// IPs, that may (or may not) connect to mammoth.
+type AccessConfig struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+}
+
+// BackendConfig restricts the addresses and ports mammoth may connect to
+// once a backend host has been resolved.
+type BackendConfig struct {
+	Allow         []string `mapstructure:"allow"`
+	Deny          []string `mapstructure:"deny"`
+	Ports         []int    `mapstructure:"ports"`
+	AllowLoopback bool     `mapstructure:"allowloopback"`
+}
+
+// PolicyConfig holds the per-session rules. A target's policy overrides
+// the global one field by field; unset (zero or nil) values are inherited.
+type PolicyConfig struct {
+	IdleTimeout              time.Duration `mapstructure:"idletimeout"`
+	IdleInTransactionTimeout time.Duration `mapstructure:"idleintransactiontimeout"`
+	MaxSessionDuration       time.Duration `mapstructure:"maxsessionduration"`
+	GuardDestructive         *bool         `mapstructure:"guarddestructive"`
+	MaxRows                  int           `mapstructure:"maxrows"`
+	Record                   *bool         `mapstructure:"record"`
+	AuditFailClosed          *bool         `mapstructure:"auditfailclosed"`
+	AuditHoldTimeout         time.Duration `mapstructure:"auditholdtimeout"`
+}
+
+// BreakGlassConfig controls emergency access for users who would otherwise
+// be denied.
+type BreakGlassConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	Users           []string `mapstructure:"users"`
+	MinReasonLength int      `mapstructure:"minreasonlength"`
+	AlertWebhook    string   `mapstructure:"alertwebhook"`
+}
+
+// QuotaConfig caps the data volume sent to clients. Sizes accept units,
+// e.g. "500MB" or "2GiB".
+type QuotaConfig struct {
+	Name         string        `mapstructure:"name"`
+	Scope        string        `mapstructure:"scope"`
+	Users        []string      `mapstructure:"users"`
+	Targets      []string      `mapstructure:"targets"`
+	Window       time.Duration `mapstructure:"window"`
+	Limit        string        `mapstructure:"limit"`
+	Action       string        `mapstructure:"action"`
+	ThrottleRate string        `mapstructure:"throttlerate"`
+}
+
+// DenylistConfig extends the built-in list of denied server-side
+// functions.
+type DenylistConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Functions    []string `mapstructure:"functions"`
+	FunctionOIDs []int32  `mapstructure:"functionoids"`
+}
+
+// AuditTLSConfig holds the TLS settings of network audit sinks.
+type AuditTLSConfig struct {
+	CA         string `mapstructure:"ca"`
+	Cert       string `mapstructure:"cert"`
+	Key        string `mapstructure:"key"`
+	ServerName string `mapstructure:"servername"`
+	SkipVerify bool   `mapstructure:"skipverify"`
+}
+
+// AuditSASLConfig holds the SASL credentials of Kafka audit sinks.
+type AuditSASLConfig struct {
+	Mechanism    string `mapstructure:"mechanism"`
+	Username     string `mapstructure:"username"`
+	PasswordFile string `mapstructure:"passwordfile"`
+}
+
+// AuditSinkConfig describes one destination of audit events.
+type AuditSinkConfig struct {
+	Type       string         `mapstructure:"type"`
+	Format     string         `mapstructure:"format"`
+	BufferSize int            `mapstructure:"buffersize"`
+	TLS        AuditTLSConfig `mapstructure:"tls"`
+
+	Path            string        `mapstructure:"path"`
+	SegmentInterval time.Duration `mapstructure:"segmentinterval"`
+	MaxSize         string        `mapstructure:"maxsize"`
+	SigningKey      string        `mapstructure:"signingkey"`
+	Compress        string        `mapstructure:"compress"`
+	Retention       time.Duration `mapstructure:"retention"`
+
+	Network  string `mapstructure:"network"`
+	Address  string `mapstructure:"address"`
+	Facility string `mapstructure:"facility"`
+	AppName  string `mapstructure:"appname"`
+
+	URL           string            `mapstructure:"url"`
+	Headers       map[string]string `mapstructure:"headers"`
+	SecretFile    string            `mapstructure:"secretfile"`
+	BatchSize     int               `mapstructure:"batchsize"`
+	FlushInterval time.Duration     `mapstructure:"flushinterval"`
+	MaxRetries    int               `mapstructure:"maxretries"`
+	Timeout       time.Duration     `mapstructure:"timeout"`
+	DeadLetter    string            `mapstructure:"deadletter"`
+
+	Brokers  []string        `mapstructure:"brokers"`
+	Topic    string          `mapstructure:"topic"`
+	ClientID string          `mapstructure:"clientid"`
+	SASL     AuditSASLConfig `mapstructure:"sasl"`
+}
+
+// AuditRedactionRuleConfig redacts the values of certain columns, or
+// values matching a pattern.
+type AuditRedactionRuleConfig struct {
+	Name    string   `mapstructure:"name"`
+	Columns []string `mapstructure:"columns"`
+	Pattern string   `mapstructure:"pattern"`
+	Action  string   `mapstructure:"action"`
+}
+
+// AuditRedactionConfig controls what statement values reach audit records.
+type AuditRedactionConfig struct {
+	Normalize   bool                       `mapstructure:"normalize"`
+	Params      string                     `mapstructure:"params"`
+	HashKeyFile string                     `mapstructure:"hashkeyfile"`
+	Rules       []AuditRedactionRuleConfig `mapstructure:"rules"`
+}
+
+// AuditClassConfig sets how statements of one class are audited.
+type AuditClassConfig struct {
+	Log   string `mapstructure:"log"`
+	Alert bool   `mapstructure:"alert"`
+}
+
+// AuditChainConfig enables tamper-evident hash chaining of audit records.
+type AuditChainConfig struct {
+	Enabled            bool          `mapstructure:"enabled"`
+	CheckpointInterval time.Duration `mapstructure:"checkpointinterval"`
+	CheckpointRecords  int           `mapstructure:"checkpointrecords"`
+}
+
+// AuditAlertMatchConfig holds the regular expressions an event's fields
+// must match for an alert rule to count it.
+type AuditAlertMatchConfig struct {
+	Type     string `mapstructure:"type"`
+	User     string `mapstructure:"user"`
+	Target   string `mapstructure:"target"`
+	Database string `mapstructure:"database"`
+	Client   string `mapstructure:"client"`
+	Session  string `mapstructure:"session"`
+	Message  string `mapstructure:"message"`
+	Class    string `mapstructure:"class"`
+	Relation string `mapstructure:"relation"`
+	Query    string `mapstructure:"query"`
+	Decision string `mapstructure:"decision"`
+	Reason   string `mapstructure:"reason"`
+	Severity string `mapstructure:"severity"`
+	SQLState string `mapstructure:"sqlstate"`
+	Error    string `mapstructure:"error"`
+	// "true" or "false"
+	BreakGlass string `mapstructure:"breakglass"`
+}
+
+// AuditAlertRuleConfig fires an alert when Count matching events happen
+// within Window.
+type AuditAlertRuleConfig struct {
+	Name         string                `mapstructure:"name"`
+	Description  string                `mapstructure:"description"`
+	Severity     string                `mapstructure:"severity"`
+	Match        AuditAlertMatchConfig `mapstructure:"match"`
+	Count        int                   `mapstructure:"count"`
+	Window       time.Duration         `mapstructure:"window"`
+	GroupBy      []string              `mapstructure:"groupby"`
+	Suppress     *time.Duration        `mapstructure:"suppress"`
+	Destinations []string              `mapstructure:"destinations"`
+}
+
+// AuditAlertDestinationConfig is a named sink alerts are sent to.
+type AuditAlertDestinationConfig struct {
+	Name            string `mapstructure:"name"`
+	AuditSinkConfig `mapstructure:",squash"`
+}
+
+// AuditAlertsConfig holds the alert rules evaluated on audit events.
+type AuditAlertsConfig struct {
+	Destinations []AuditAlertDestinationConfig `mapstructure:"destinations"`
+	Rules        []AuditAlertRuleConfig        `mapstructure:"rules"`
+	RateLimit    int                           `mapstructure:"ratelimit"`
+}
+
+type AuditConfig struct {
+	Sinks     []AuditSinkConfig           `mapstructure:"sinks"`
+	Chain     AuditChainConfig            `mapstructure:"chain"`
+	Redaction AuditRedactionConfig        `mapstructure:"redaction"`
+	Classes   map[string]AuditClassConfig `mapstructure:"classes"`
+	Alerts    AuditAlertsConfig           `mapstructure:"alerts"`
+}
+
+// StatsConfig enables statistics on the statements run through mammoth.
+type StatsConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	MaxEntries      int           `mapstructure:"maxentries"`
+	SummaryInterval time.Duration `mapstructure:"summaryinterval"`
+}
+
+// AdminConfig enables the admin HTTP interface.
+type AdminConfig struct {
+	Bind      string `mapstructure:"bind"`
+	TokenFile string `mapstructure:"tokenfile"`
+}
+
+// RecordingConfig sets where session recordings are written.
+type RecordingConfig struct {
+	Dir string `mapstructure:"dir"`
+}
+
+// TargetConfig holds the settings that apply to backends whose host
+// matches the Host regexp.
+type TargetConfig struct {
+	Name   string       `mapstructure:"name"`
+	Host   string       `mapstructure:"host"`
+	Access AccessConfig `mapstructure:"access"`
+	Policy PolicyConfig `mapstructure:"policy"`
+}
+
 type Config struct {
-	Bind        string       `mapstructure:"bind"`
-	Server      ServerConfig `mapstructure:"server"`
-	Client      ClientConfig `mapstructure:"client"`
-	HostRegex   string       `mapstructure:"hostregex"`
-	RedisServer string       `mapstructure:"redisserver"`
+	Bind           string           `mapstructure:"bind"`
+	Server         ServerConfig     `mapstructure:"server"`
+	Client         ClientConfig     `mapstructure:"client"`
+	HostRegex      string           `mapstructure:"hostregex"`
+	RedisServer    string           `mapstructure:"redisserver"`
+	Access         AccessConfig     `mapstructure:"access"`
+	TrustedProxies []string         `mapstructure:"trustedproxies"`
+	Backends       BackendConfig    `mapstructure:"backends"`
+	Policy         PolicyConfig     `mapstructure:"policy"`
+	BreakGlass     BreakGlassConfig `mapstructure:"breakglass"`
+	Quotas         []QuotaConfig    `mapstructure:"quotas"`
+	Denylist       DenylistConfig   `mapstructure:"denylist"`
+	Audit          AuditConfig      `mapstructure:"audit"`
+	Stats          StatsConfig      `mapstructure:"stats"`
+	Admin          AdminConfig      `mapstructure:"admin"`
+	Recording      RecordingConfig  `mapstructure:"recording"`
+	Targets        []TargetConfig   `mapstructure:"targets"`
 }
 
 func SetConfigPath(path string) {
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"time"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Policy holds the rules enforced on a single session. A zero value
+// disables the corresponding rule.
+type Policy struct {
+	IdleTimeout              time.Duration
+	IdleInTransactionTimeout time.Duration
+	MaxSessionDuration       time.Duration
+	// Reject unqualified DELETE/UPDATE, TRUNCATE and DROP unless the
+	// query carries a confirmation comment
+	GuardDestructive bool
+	// Maximum number of rows a single statement may return
+	MaxRows int
+	// Record the messages the client sends, for replay
+	Record bool
+	// Reject statements while audit events can't be delivered, after
+	// holding them for up to AuditHoldTimeout
+	AuditFailClosed  bool
+	AuditHoldTimeout time.Duration
+}
+
+// policyFromFile converts a policy section. Rules that are not set in f
+// keep their value from defaults.
+func policyFromFile(f file.PolicyConfig, defaults Policy) Policy {
+	p := defaults
+	if f.IdleTimeout != 0 {
+		p.IdleTimeout = f.IdleTimeout
+	}
+	if f.IdleInTransactionTimeout != 0 {
+		p.IdleInTransactionTimeout = f.IdleInTransactionTimeout
+	}
+	if f.MaxSessionDuration != 0 {
+		p.MaxSessionDuration = f.MaxSessionDuration
+	}
+	if f.MaxRows != 0 {
+		p.MaxRows = f.MaxRows
+	}
+	if f.GuardDestructive != nil {
+		p.GuardDestructive = *f.GuardDestructive
+	}
+	if f.Record != nil {
+		p.Record = *f.Record
+	}
+	if f.AuditFailClosed != nil {
+		p.AuditFailClosed = *f.AuditFailClosed
+	}
+	if f.AuditHoldTimeout != 0 {
+		p.AuditHoldTimeout = f.AuditHoldTimeout
+	}
+	return p
+}
+
+// PolicyFor returns the policy that applies to sessions to host.
+func (c *Config) PolicyFor(host string) Policy {
+	if t := c.TargetFor(host); t != nil {
+		return t.Policy
+	}
+	return c.Policy
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Scopes a data volume quota can be counted over.
+const (
+	QuotaScopeSession    = "session"
+	QuotaScopeUser       = "user"
+	QuotaScopeTarget     = "target"
+	QuotaScopeUserTarget = "user-target"
+)
+
+// Actions taken once a quota is exceeded.
+const (
+	QuotaActionAlert     = "alert"
+	QuotaActionThrottle  = "throttle"
+	QuotaActionTerminate = "terminate"
+)
+
+// Quota caps the volume of data sent from backends to clients within a
+// time window.
+type Quota struct {
+	Name    string
+	Scope   string
+	Users   []string
+	Targets []string
+	// Zero means the quota never resets, which only makes sense for
+	// session quotas
+	Window time.Duration
+	Limit  int64
+	Action string
+	// Bytes per second a throttled session may receive
+	ThrottleRate int64
+}
+
+func quotasFromFile(fs []file.QuotaConfig) ([]*Quota, error) {
+	quotas := make([]*Quota, 0, len(fs))
+	for i, f := range fs {
+		q, err := quotaFromFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("Error in quota %d (%s): %w", i, f.Name, err)
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, nil
+}
+
+func quotaFromFile(f file.QuotaConfig) (*Quota, error) {
+	q := &Quota{
+		Name:    f.Name,
+		Scope:   f.Scope,
+		Users:   f.Users,
+		Targets: f.Targets,
+		Window:  f.Window,
+		Action:  f.Action,
+	}
+
+	switch q.Scope {
+	case "":
+		q.Scope = QuotaScopeUser
+	case QuotaScopeSession, QuotaScopeUser, QuotaScopeTarget, QuotaScopeUserTarget:
+	default:
+		return nil, fmt.Errorf("Unknown scope: %s", q.Scope)
+	}
+
+	if q.Window == 0 && q.Scope != QuotaScopeSession {
+		return nil, errors.New("Missing window")
+	}
+
+	limit, err := ParseSize(f.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid limit: %w", err)
+	}
+	if limit <= 0 {
+		return nil, errors.New("Missing limit")
+	}
+	q.Limit = limit
+
+	switch q.Action {
+	case "":
+		q.Action = QuotaActionAlert
+	case QuotaActionAlert, QuotaActionTerminate:
+	case QuotaActionThrottle:
+		rate, err := ParseSize(f.ThrottleRate)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid throttle rate: %w", err)
+		}
+		if rate <= 0 {
+			return nil, errors.New("Missing throttle rate")
+		}
+		q.ThrottleRate = rate
+	default:
+		return nil, fmt.Errorf("Unknown action: %s", q.Action)
+	}
+
+	if q.Name == "" {
+		q.Name = fmt.Sprintf("%s-%s-%s", q.Scope, f.Limit, q.Window)
+	}
+	return q, nil
+}
+
+// Applies reports whether the quota covers sessions of user to target.
+// Empty user or target lists match everything.
+func (q *Quota) Applies(user, target string) bool {
+	return matchesAny(q.Users, user) && matchesAny(q.Targets, target)
+}
+
+// Key returns the name usage is accumulated under for a session of user
+// to target.
+func (q *Quota) Key(user, target string) string {
+	switch q.Scope {
+	case QuotaScopeUser:
+		return q.Name + "\x00" + user
+	case QuotaScopeTarget:
+		return q.Name + "\x00" + target
+	default:
+		return q.Name + "\x00" + user + "\x00" + target
+	}
+}
+
+func matchesAny(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a byte size such as "500MB", "2GiB" or "1024". An empty
+// string is zero.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * float64(mult)), nil
+}
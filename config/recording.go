@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Recording sets where the sessions of policies with Record set are
+// recorded, one file per session.
+type Recording struct {
+	Dir string
+}
+
+// recordingFromFile converts the recording section. A directory is
+// required as soon as a policy records sessions, and must exist.
+func recordingFromFile(f file.RecordingConfig, policy Policy, targets []*Target) (Recording, error) {
+	r := Recording{Dir: f.Dir}
+
+	records := policy.Record
+	for _, t := range targets {
+		records = records || t.Policy.Record
+	}
+	if !records {
+		return r, nil
+	}
+
+	if r.Dir == "" {
+		return Recording{}, errors.New("Missing recording directory")
+	}
+	fi, err := os.Stat(r.Dir)
+	if err != nil {
+		return Recording{}, fmt.Errorf("Invalid recording directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return Recording{}, fmt.Errorf("Invalid recording directory: %s is not a directory", r.Dir)
+	}
+	return r, nil
+}
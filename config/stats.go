@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Defaults for statement statistics, in line with pg_stat_statements.
+const (
+	defaultStatsMaxEntries      = 5000
+	defaultStatsSummaryInterval = 10 * time.Minute
+)
+
+// Stats controls statistics on the statements run through mammoth,
+// aggregated by fingerprint, user and target.
+type Stats struct {
+	Enabled bool
+	// Least-called entries are evicted beyond this
+	MaxEntries int
+	// How often the statistics of the past interval are audited
+	SummaryInterval time.Duration
+}
+
+// Admin configures the admin HTTP interface. It is disabled without a
+// bind address. Requests must carry Token as a bearer token if it is set,
+// which it must be unless Bind is a loopback address.
+type Admin struct {
+	Bind  string
+	Token string
+}
+
+func statsFromFile(f file.StatsConfig) Stats {
+	s := Stats{
+		Enabled:         f.Enabled,
+		MaxEntries:      f.MaxEntries,
+		SummaryInterval: f.SummaryInterval,
+	}
+	if s.MaxEntries <= 0 {
+		s.MaxEntries = defaultStatsMaxEntries
+	}
+	if s.SummaryInterval <= 0 {
+		s.SummaryInterval = defaultStatsSummaryInterval
+	}
+	return s
+}
+
+func adminFromFile(f file.AdminConfig) (Admin, error) {
+	a := Admin{Bind: f.Bind}
+	if f.TokenFile != "" {
+		token, err := ioutil.ReadFile(f.TokenFile)
+		if err != nil {
+			return Admin{}, fmt.Errorf("Error loading admin token: %w", err)
+		}
+		a.Token = string(bytes.TrimSpace(token))
+		if a.Token == "" {
+			return Admin{}, errors.New("Empty admin token file")
+		}
+	}
+	if a.Bind != "" && a.Token == "" && !isLoopbackBind(a.Bind) {
+		return Admin{}, fmt.Errorf("Admin bind %v is not a loopback address and no tokenFile is set", a.Bind)
+	}
+	return a, nil
+}
+
+// isLoopbackBind reports whether a listen address only accepts local
+// connections. An empty host listens on every interface.
+func isLoopbackBind(bind string) bool {
+	host, _, err := net.SplitHostPort(bind)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
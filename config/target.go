@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+// Target holds the settings for a group of backend hosts.
+type Target struct {
+	Name      string
+	HostRegex *regexp.Regexp
+	Access    AccessList
+	Policy    Policy
+}
+
+func targetFromFile(f file.TargetConfig, defaults Policy) (*Target, error) {
+	if f.Host == "" {
+		return nil, errors.New("Missing host regexp")
+	}
+	hostRegex, err := regexp.Compile(f.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := accessListFromFile(f.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	name := f.Name
+	if name == "" {
+		name = f.Host
+	}
+
+	return &Target{
+		Name:      name,
+		HostRegex: hostRegex,
+		Access:    access,
+		Policy:    policyFromFile(f.Policy, defaults),
+	}, nil
+}
+
+func targetsFromFile(fs []file.TargetConfig, defaults Policy) ([]*Target, error) {
+	targets := make([]*Target, 0, len(fs))
+	for i, f := range fs {
+		t, err := targetFromFile(f, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("Error in target %d (%s): %w", i, f.Name, err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// TargetFor returns the first configured target whose host regexp matches
+// host, or nil if there is none.
+func (c *Config) TargetFor(host string) *Target {
+	for _, t := range c.Targets {
+		if t.HostRegex.MatchString(host) {
+			return t
+		}
+	}
+	return nil
+}
@@ -58,6 +58,12 @@ func (p *Buffer) WriteTo(w io.Writer) error {
 	return err
 }
 
+// Message returns the buffer contents as the body of a message of the
+// given type.
+func (p *Buffer) Message(msgType byte) *Message {
+	return &Message{Type: msgType, Body: p.b.Bytes()}
+}
+
 func (p *Buffer) Read(b []byte) (int, error) {
 	if p.r == nil {
 		len := p.b.Len() + 4
@@ -37,6 +37,8 @@ const (
 	NoticeMessageType          byte = 'N'
 	PasswordMessageType        byte = 'p'
 	ReadyForQueryMessageType   byte = 'Z'
+	PortalSuspendedMessageType byte = 's'
+	CopyOutResponseMessageType byte = 'H'
 
 	BindMessageType         byte = 'B'
 	CloseMessageType        byte = 'C'
@@ -52,6 +54,13 @@ const (
 	TerminateMessageType    byte = 'X'
 )
 
+/* PostgreSQL ReadyForQuery transaction status indicators. */
+const (
+	TxStatusIdle          byte = 'I'
+	TxStatusInTransaction byte = 'T'
+	TxStatusFailed        byte = 'E'
+)
+
 /* PostgreSQL Authentication Method constants. */
 const (
 	AuthenticationOk          int32 = 0
@@ -45,6 +45,12 @@ const (
 	ErrorCodeConnectionFailure     string = "08006"
 	ErrorCodeClientUnableToConnect string = "08001"
 	ErrorCodeServerRejected        string = "08004"
+	ErrorCodeAdminShutdown         string = "57P01"
+	ErrorCodeSyntaxError           string = "42601"
+	ErrorCodeInsufficientPrivilege string = "42501"
+	ErrorCodeProgramLimitExceeded  string = "54000"
+	ErrorCodeSystemError           string = "58000"
+	ErrorCodeInFailedTransaction   string = "25P02"
 )
 
 type Error struct {
@@ -87,3 +93,34 @@ func WriteError(w io.Writer, e Error) error {
 	msg.WriteByte(0)
 	return msg.WriteTo(w)
 }
+
+// ReadError parses the fields of an ErrorResponse (or NoticeResponse)
+// message body. Fields mammoth doesn't model are skipped.
+func ReadError(m *Reader) (Error, error) {
+	e := Error{}
+	for {
+		field, err := m.ReadByte()
+		if err != nil {
+			return e, err
+		}
+		if field == 0 {
+			return e, nil
+		}
+		value, err := m.ReadString()
+		if err != nil {
+			return e, err
+		}
+		switch field {
+		case ErrorFieldSeverity:
+			e.Severity = value
+		case ErrorFieldCode:
+			e.Code = value
+		case ErrorFieldMessage:
+			e.Message = value
+		case ErrorFieldMessageDetail:
+			e.Detail = value
+		case ErrorFieldMessageHint:
+			e.Hint = value
+		}
+	}
+}
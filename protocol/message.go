@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Message is a complete, typed protocol message. Unlike Reader, which
+// streams the message body, a Message is read in full so it can be
+// inspected before deciding whether (and where) to forward it.
+type Message struct {
+	Type byte
+	Body []byte
+}
+
+// ReadTypedMessage reads a message type byte followed by the message
+// length and body.
+func ReadTypedMessage(r io.Reader) (*Message, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header[:1]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, header[1:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	sz := int32(binary.BigEndian.Uint32(header[1:]))
+	if sz < 4 {
+		return nil, errors.New("Message size < 4 or overflow")
+	}
+
+	body := make([]byte, sz-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return &Message{Type: header[0], Body: body}, nil
+}
+
+// Len returns the length of the message as encoded on the wire, including
+// the type byte.
+func (m *Message) Len() int {
+	return len(m.Body) + 5
+}
+
+// Reader returns a Reader over the message body.
+func (m *Message) Reader() *Reader {
+	return &Reader{
+		Len: int32(len(m.Body) + 4),
+		r:   bufio.NewReader(bytes.NewReader(m.Body)),
+	}
+}
+
+// Encode writes the message, type byte included, to w.
+func (m *Message) Encode(w io.Writer) error {
+	if len(m.Body)+4 > math.MaxInt32 {
+		return errors.New("Length of message too large")
+	}
+	buf := make([]byte, 5, m.Len())
+	buf[0] = m.Type
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(m.Body)+4))
+	buf = append(buf, m.Body...)
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// readyStatus returns the transaction status to report to the client in
+// the ReadyForQuery ending a sync group, given the backend's. An error
+// mammoth answers part of a transaction block with fails the transaction,
+// as a backend error would, but the backend knows nothing about it: the
+// transaction is reported failed until it ends, and checkAborted holds the
+// client to that.
+func (p *ProxyConnection) readyStatus(status byte, errored bool) byte {
+	switch status {
+	case protocol.TxStatusIdle:
+		p.txAborted.Store(false)
+	case protocol.TxStatusInTransaction:
+		if errored || p.txAborted.Load() {
+			p.txAborted.Store(true)
+			return protocol.TxStatusFailed
+		}
+	}
+	return status
+}
+
+// checkAborted applies the rules of a failed transaction to a message sent
+// while mammoth holds the transaction failed, recording the outcome. Only
+// a query string ending the transaction gets through, a COMMIT being
+// turned into the ROLLBACK the backend would have made of it; ROLLBACK TO
+// SAVEPOINT brings the transaction back. It returns the error to reject
+// the message with, or nil if it may be forwarded. Bind and Execute are
+// left alone, as whatever they run is rolled back.
+func (p *ProxyConnection) checkAborted(m *protocol.Message, stmt *audit.Statement, outcome *audit.Outcome) *protocol.Error {
+	if !p.txAborted.Load() {
+		return nil
+	}
+
+	stmts := query.Split(stmt.Query)
+	if m.Type != protocol.FunctionCallMessageType {
+		if len(stmts) == 0 {
+			return nil
+		}
+		t := stmts[0].Tokens
+		switch {
+		case t[0].Is("ROLLBACK") || t[0].Is("ABORT"):
+			for _, next := range t[1:] {
+				if next.Is("TO") {
+					p.txAborted.Store(false)
+				}
+			}
+			return nil
+		case (t[0].Is("COMMIT") || t[0].Is("END")) && !(len(t) > 1 && t[1].Is("PREPARED")):
+			q := query.Rewrite(stmt.Query, t[:1], func(query.Token) string { return "ROLLBACK" })
+			rewriteQuery(m, stmt, q)
+			outcome.Reason = "COMMIT of a failed transaction, rolled back"
+			return nil
+		}
+	}
+
+	outcome.Decision = audit.DecisionRejected
+	outcome.Reason = "in a failed transaction"
+	return &protocol.Error{
+		Severity: protocol.ErrorSeverityError,
+		Code:     protocol.ErrorCodeInFailedTransaction,
+		Message:  "current transaction is aborted, commands ignored until end of transaction block",
+	}
+}
+
+// rewriteQuery replaces the query string of a simple Query or Parse
+// message, stmt being what was read from it.
+func rewriteQuery(m *protocol.Message, stmt *audit.Statement, q string) {
+	body := protocol.NewBuffer()
+	rest := m.Body
+	if m.Type == protocol.ParseMessageType {
+		body.WriteString(stmt.PreparedStatement)
+		rest = rest[len(stmt.PreparedStatement)+1:]
+	}
+	body.WriteString(q)
+	rest = rest[len(stmt.Query)+1:]
+	body.Write(rest)
+	m.Body = body.Message(m.Type).Body
+}
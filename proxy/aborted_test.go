@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRejectionFailsTransaction(t *testing.T) {
+	backend := newTestBackend(t, "")
+	conn := testConnect(t, startTestProxy(t, guardConfig()), backend)
+
+	if err := testExec(t, conn, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testExec(t, conn, "DROP TABLE t"); sqlState(err) != protocol.ErrorCodeInsufficientPrivilege {
+		t.Fatalf("DROP TABLE: got %v, want a rejection", err)
+	}
+	if status := conn.TxStatus(); status != protocol.TxStatusFailed {
+		t.Errorf("transaction status %c after the rejection, want E", status)
+	}
+	if err := testExec(t, conn, "INSERT INTO t VALUES (1)"); sqlState(err) != protocol.ErrorCodeInFailedTransaction {
+		t.Errorf("INSERT in the failed transaction: got %v, want SQLSTATE 25P02", err)
+	}
+	if err := testExec(t, conn, "COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if status := conn.TxStatus(); status != protocol.TxStatusIdle {
+		t.Errorf("transaction status %c after COMMIT, want I", status)
+	}
+	if err := testExec(t, conn, "SELECT 1"); err != nil {
+		t.Errorf("SELECT after the transaction: %v", err)
+	}
+
+	want := []string{"BEGIN", "ROLLBACK", "SELECT 1"}
+	if got := backend.received(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("backend received %q, want %q", got, want)
+	}
+}
+
+func TestRejectionInForwardedBatchFailsTransaction(t *testing.T) {
+	backend := newTestBackend(t, "")
+	conn := testConnect(t, startTestProxy(t, guardConfig()), backend)
+
+	if err := testExec(t, conn, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	batch := &pgconn.Batch{}
+	batch.ExecParams("SELECT 1", nil, nil, nil, nil)
+	batch.ExecParams("DELETE FROM t", nil, nil, nil, nil)
+	_, err := conn.ExecBatch(ctx, batch).ReadAll()
+	if sqlState(err) != protocol.ErrorCodeInsufficientPrivilege {
+		t.Fatalf("batch: got %v, want a rejection", err)
+	}
+	if status := conn.TxStatus(); status != protocol.TxStatusFailed {
+		t.Errorf("transaction status %c after the rejection, want E", status)
+	}
+
+	// A savepoint rolled back to brings the transaction back
+	if err := testExec(t, conn, "ROLLBACK TO SAVEPOINT s"); err != nil {
+		t.Fatal(err)
+	}
+	if status := conn.TxStatus(); status != protocol.TxStatusInTransaction {
+		t.Errorf("transaction status %c after ROLLBACK TO SAVEPOINT, want T", status)
+	}
+	if err := testExec(t, conn, "SELECT 2"); err != nil {
+		t.Errorf("SELECT after ROLLBACK TO SAVEPOINT: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// audit emits an audit event for the session. The event gets a copy of the
+// session as it is now, since sinks may format it after the session has
+// changed. Failing to deliver it is an operational problem, so it goes to
+// the operational log.
+func (p *ProxyConnection) audit(e *audit.Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	s := *p.session
+	e.Session = &s
+
+	err := p.auditor.Emit(e)
+	if err != nil {
+		p.log.Errorf("Unable to write audit event: %v", err)
+	}
+	return err
+}
+
+// auditTLS records the parameters negotiated with the client.
+func (p *ProxyConnection) auditTLS(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	state := tlsConn.ConnectionState()
+
+	version, ok := tlsVersions[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	p.audit(&audit.Event{
+		Type: audit.EventSessionTLS,
+		Connection: &audit.Connection{
+			TLSVersion:  version,
+			CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		},
+	})
+}
+
+// auditPolicy records whether the session may go ahead. A rejection also
+// becomes the reason the session is closed.
+func (p *ProxyConnection) auditPolicy(decision, reason string) {
+	if decision == audit.DecisionRejected {
+		p.setCloseReason(reason)
+	}
+	p.audit(&audit.Event{
+		Type:    audit.EventSessionPolicy,
+		Outcome: &audit.Outcome{Decision: decision, Reason: reason},
+	})
+}
+
+// auditAuth looks for the outcome of authentication in a message sent by
+// the backend during startup: AuthenticationOk, or an ErrorResponse before
+// it. It returns true once the outcome is known.
+func (p *ProxyConnection) auditAuth(m *protocol.Message) bool {
+	switch m.Type {
+	case protocol.AuthenticationMessageType:
+		if len(m.Body) < 4 || int32(binary.BigEndian.Uint32(m.Body)) != protocol.AuthenticationOk {
+			return false
+		}
+		p.audit(&audit.Event{
+			Type:    audit.EventSessionAuth,
+			Outcome: &audit.Outcome{Decision: audit.DecisionAllowed},
+		})
+		return true
+
+	case protocol.ErrorMessageType:
+		p.setCloseReason("authentication failed")
+		outcome := &audit.Outcome{Decision: audit.DecisionRejected}
+		if e, err := protocol.ReadError(m.Reader()); err == nil {
+			outcome.Error = e.Message
+			outcome.SQLState = e.Code
+		}
+		p.audit(&audit.Event{
+			Type:    audit.EventSessionAuth,
+			Outcome: outcome,
+		})
+		return true
+	}
+	return false
+}
+
+// trackStatement remembers on g, the sync group of a message forwarded to
+// the backend, the statement the message runs or prepares: the last one
+// forwarded in the group is the one that errors the backend reports for
+// the group are audited against. It must be called before the message is
+// forwarded.
+func (p *ProxyConnection) trackStatement(g *syncGroup, stmt *audit.Statement) {
+	if g == nil {
+		return
+	}
+
+	s := &audit.Statement{
+		Message:   stmt.Message,
+		Classes:   stmt.Classes,
+		Relations: stmt.Relations,
+	}
+	switch stmt.Message {
+	case "SimpleQuery", "Parse":
+		s.Query = stmt.Query
+		s.PreparedStatement = stmt.PreparedStatement
+	case "Execute":
+		s.Portal = stmt.Portal
+		if q := p.portals[stmt.Portal]; q != nil {
+			s.Query = q.query
+		}
+	case "FunctionCall":
+		s.FunctionOID = stmt.FunctionOID
+	default:
+		return
+	}
+
+	p.groupsMtx.Lock()
+	g.stmt = s
+	p.groupsMtx.Unlock()
+}
+
+// auditBackendError records an error the backend returned to the client
+// once the session is established, with the statement of the sync group
+// it answers, if any.
+func (p *ProxyConnection) auditBackendError(m *protocol.Message) {
+	outcome := &audit.Outcome{Decision: audit.DecisionAllowed}
+	if e, err := protocol.ReadError(m.Reader()); err == nil {
+		outcome.Error = e.Message
+		outcome.SQLState = e.Code
+	}
+
+	var stmt *audit.Statement
+	p.groupsMtx.Lock()
+	if len(p.groups) > 0 {
+		stmt = p.groups[0].stmt
+	}
+	p.groupsMtx.Unlock()
+
+	p.audit(&audit.Event{
+		Type:      audit.EventStatementError,
+		Statement: stmt,
+		Outcome:   outcome,
+	})
+}
+
+// auditClosed records the end of the session, with err being what ended
+// HandleConnection.
+func (p *ProxyConnection) auditClosed(err error) {
+	reason := p.getCloseReason()
+	if reason == "" {
+		if err != nil && err != io.EOF {
+			reason = err.Error()
+		} else {
+			reason = "client disconnected"
+		}
+	}
+
+	p.audit(&audit.Event{
+		Type: audit.EventSessionClosed,
+		Connection: &audit.Connection{
+			DurationMs:      time.Since(p.accepted).Milliseconds(),
+			BytesFromClient: p.bytesFromClient.Load(),
+			BytesToClient:   p.bytesToClient,
+			Statements:      p.statements.Load(),
+			CloseReason:     reason,
+			Recording:       p.recording,
+		},
+	})
+}
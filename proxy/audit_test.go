@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+func TestAuditCopiesSession(t *testing.T) {
+	sink := &eventSink{}
+	p := &ProxyConnection{
+		auditor: audit.New(sink),
+		session: &audit.Session{ID: "s", User: "alice"},
+	}
+
+	p.audit(&audit.Event{Type: audit.EventSessionStartup})
+	p.session.BreakGlass = true
+	p.audit(&audit.Event{Type: audit.EventSessionPolicy})
+
+	if s := sink.find(audit.EventSessionStartup)[0].Session; s.BreakGlass || s.User != "alice" {
+		t.Errorf("startup event has session %+v, want it as it was when emitted", s)
+	}
+	if s := sink.find(audit.EventSessionPolicy)[0].Session; !s.BreakGlass {
+		t.Errorf("policy event has session %+v, want break-glass set", s)
+	}
+}
+
+func TestAuditBackendError(t *testing.T) {
+	backend := newTestBackend(t, "")
+	sink := &eventSink{}
+	conn := testConnect(t, startTestProxy(t, &file.Config{}, sink), backend)
+
+	if err := testExec(t, conn, "FAIL"); sqlState(err) != "22012" {
+		t.Fatalf("got %v, want SQLSTATE 22012", err)
+	}
+	if err := testExec(t, conn, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := sink.find(audit.EventStatementError)
+	if len(events) != 1 {
+		t.Fatalf("%d statement errors audited, want 1", len(events))
+	}
+	e := events[0]
+	if e.Outcome.SQLState != "22012" || e.Outcome.Error != "division by zero" {
+		t.Errorf("outcome %+v, want the backend's error", e.Outcome)
+	}
+	if e.Statement == nil || e.Statement.Message != "SimpleQuery" || e.Statement.Query != "FAIL" {
+		t.Errorf("statement %+v, want the query that failed", e.Statement)
+	}
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/config/file"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// testBackend is a minimal PostgreSQL server. It asks for a cleartext
+// password if one is set, and answers every query with an empty result,
+// except for these:
+//
+//   - BEGIN, COMMIT and ROLLBACK, which track the transaction status
+//   - FAIL, which fails with SQLSTATE 22012
+type testBackend struct {
+	ln       net.Listener
+	password string
+
+	mtx     sync.Mutex
+	queries []string
+}
+
+func newTestBackend(t *testing.T, password string) *testBackend {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBackend{ln: ln, password: password}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return b
+}
+
+func (b *testBackend) addr() string {
+	return b.ln.Addr().String()
+}
+
+// received returns the queries the backend received, from simple Query
+// and Parse messages.
+func (b *testBackend) received() []string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return append([]string(nil), b.queries...)
+}
+
+func (b *testBackend) serve(conn net.Conn) {
+	defer conn.Close()
+	be := pgproto3.NewBackend(conn, conn)
+
+	msg, err := be.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := msg.(*pgproto3.StartupMessage); !ok {
+		return
+	}
+	if b.password != "" {
+		be.Send(&pgproto3.AuthenticationCleartextPassword{})
+		if be.Flush() != nil || be.SetAuthType(pgproto3.AuthTypeCleartextPassword) != nil {
+			return
+		}
+		msg, err := be.Receive()
+		if err != nil {
+			return
+		}
+		if pw, ok := msg.(*pgproto3.PasswordMessage); !ok || pw.Password != b.password {
+			be.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"})
+			be.Flush()
+			return
+		}
+	}
+	be.Send(&pgproto3.AuthenticationOk{})
+	be.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 2})
+	be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if be.Flush() != nil {
+		return
+	}
+
+	status := byte('I')
+	// Set after an error in the extended protocol, until Sync
+	failed := false
+	for {
+		msg, err := be.Receive()
+		if err != nil {
+			return
+		}
+		switch m := msg.(type) {
+		case *pgproto3.Query:
+			b.mtx.Lock()
+			b.queries = append(b.queries, m.String)
+			b.mtx.Unlock()
+			status = b.run(be, m.String, status)
+			be.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+			be.Flush()
+		case *pgproto3.Parse:
+			if failed {
+				continue
+			}
+			b.mtx.Lock()
+			b.queries = append(b.queries, m.Query)
+			b.mtx.Unlock()
+			be.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Bind:
+			if !failed {
+				be.Send(&pgproto3.BindComplete{})
+			}
+		case *pgproto3.Describe:
+			if !failed {
+				be.Send(&pgproto3.NoData{})
+			}
+		case *pgproto3.Execute:
+			if !failed {
+				be.Send(&pgproto3.CommandComplete{CommandTag: []byte("OK")})
+			}
+		case *pgproto3.Sync:
+			failed = false
+			be.Send(&pgproto3.ReadyForQuery{TxStatus: status})
+			be.Flush()
+		case *pgproto3.Terminate:
+			return
+		}
+	}
+}
+
+// run answers a simple query, and returns the new transaction status.
+func (b *testBackend) run(be *pgproto3.Backend, query string, status byte) byte {
+	switch strings.ToUpper(strings.TrimRight(strings.TrimSpace(query), ";")) {
+	case "BEGIN":
+		be.Send(&pgproto3.CommandComplete{CommandTag: []byte("BEGIN")})
+		return 'T'
+	case "COMMIT":
+		if status == 'E' {
+			be.Send(&pgproto3.CommandComplete{CommandTag: []byte("ROLLBACK")})
+		} else {
+			be.Send(&pgproto3.CommandComplete{CommandTag: []byte("COMMIT")})
+		}
+		return 'I'
+	case "ROLLBACK":
+		be.Send(&pgproto3.CommandComplete{CommandTag: []byte("ROLLBACK")})
+		return 'I'
+	case "FAIL":
+		be.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "22012", Message: "division by zero"})
+		if status == 'T' {
+			return 'E'
+		}
+		return status
+	}
+	be.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")})
+	return status
+}
+
+// eventSink keeps the audit events it is sent.
+type eventSink struct {
+	mtx    sync.Mutex
+	events []*audit.Event
+}
+
+func (s *eventSink) Write(e *audit.Event) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *eventSink) Close() error {
+	return nil
+}
+
+// find returns the events of the given type.
+func (s *eventSink) find(typ string) []*audit.Event {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var found []*audit.Event
+	for _, e := range s.events {
+		if e.Type == typ {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// startTestProxy runs a proxy with the given configuration, without TLS
+// and allowed to reach loopback backends, and returns its address.
+func startTestProxy(t *testing.T, f *file.Config, sinks ...audit.Sink) string {
+	f.Server.AllowUnencrypted = true
+	f.Client.AllowUnencrypted = true
+	f.Backends.AllowLoopback = true
+	c, err := config.FromFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := audit.New(sinks...)
+	p := NewProxy(c, a, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.HandleConnection(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// testConnect connects to backend through the proxy at addr, adding the
+// given settings to the connection string.
+func testConnect(t *testing.T, addr string, backend *testBackend, settings ...string) *pgconn.PgConn {
+	host, port, _ := net.SplitHostPort(addr)
+	dsn := fmt.Sprintf("host=%s port=%s user=alice password='%s' dbname='%s/db' sslmode=disable connect_timeout=5 %s",
+		host, port, backend.password, backend.addr(), strings.Join(settings, " "))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+	return conn
+}
+
+// testExec runs query as a simple query and returns the error it got,
+// failing the test if no answer comes in time.
+func testExec(t *testing.T, conn *pgconn.PgConn, query string) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := conn.Exec(ctx, query).ReadAll()
+	if ctx.Err() != nil {
+		t.Fatalf("%q got no answer: %v", query, err)
+	}
+	return err
+}
+
+// sqlState returns the SQLSTATE of an error returned by the backend or
+// mammoth.
+func sqlState(err error) string {
+	if pgErr, ok := err.(*pgconn.PgError); ok {
+		return pgErr.Code
+	}
+	return ""
+}
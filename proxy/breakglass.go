@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
+)
+
+// startBreakGlass lets a session through that would otherwise have been
+// denied. Every subsequent log entry and audit event of the session is
+// tagged, and a break-glass audit event, which alert rules can pick up,
+// records the denial bypassed.
+func (p *ProxyConnection) startBreakGlass(reason, denial string) {
+	p.log = p.log.WithFields(logrus.Fields{
+		"breakGlass":       true,
+		"breakGlassReason": reason,
+	})
+	p.session.BreakGlass = true
+	p.session.BreakGlassReason = reason
+	p.log.Warnf("Break-glass access granted despite: %s", denial)
+
+	p.audit(&audit.Event{
+		Type:    audit.EventSessionBreakGlass,
+		Outcome: &audit.Outcome{Decision: audit.DecisionAllowed, Reason: denial},
+	})
+}
+
+// extractOptionsReason removes the break-glass reason from a startup
+// "options" value, returning what is left and the reason, if any. Options
+// are separated by whitespace, and a backslash escapes the next character.
+func extractOptionsReason(options string) (rest, reason string) {
+	var args []string
+	var cur strings.Builder
+	escaped, inArg := false, false
+	for _, c := range options {
+		switch {
+		case escaped:
+			cur.WriteRune('\\')
+			cur.WriteRune(c)
+			escaped, inArg = false, true
+		case c == '\\':
+			escaped = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+
+	unescape := func(s string) string {
+		var b strings.Builder
+		escaped := false
+		for _, c := range s {
+			if c == '\\' && !escaped {
+				escaped = true
+				continue
+			}
+			b.WriteRune(c)
+			escaped = false
+		}
+		return b.String()
+	}
+
+	prefix := breakGlassReasonOption + "="
+	kept := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		setting := ""
+		switch {
+		case arg == "-c" && i+1 < len(args):
+			setting = args[i+1]
+			if strings.HasPrefix(setting, prefix) {
+				i++
+			}
+		case strings.HasPrefix(arg, "-c"):
+			setting = arg[2:]
+		case strings.HasPrefix(arg, "--"):
+			setting = arg[2:]
+		}
+		if strings.HasPrefix(setting, prefix) {
+			reason = unescape(setting[len(prefix):])
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	return strings.Join(kept, " "), reason
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+func TestBreakGlassAudited(t *testing.T) {
+	backend := newTestBackend(t, "")
+	sink := &eventSink{}
+	addr := startTestProxy(t, &file.Config{
+		HostRegex:  `^db\.example\.com$`,
+		BreakGlass: file.BreakGlassConfig{Enabled: true},
+	}, sink)
+	conn := testConnect(t, addr, backend, `options='-c mammoth.reason=INC-1234\ primary\ is\ down'`)
+	if err := testExec(t, conn, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := sink.find(audit.EventSessionBreakGlass)
+	if len(events) != 1 {
+		t.Fatalf("%d break-glass events, want 1", len(events))
+	}
+	e := events[0]
+	if !e.Session.BreakGlass || e.Session.BreakGlassReason != "INC-1234 primary is down" {
+		t.Errorf("session %+v, want it tagged with the reason", e.Session)
+	}
+	if e.Outcome == nil || e.Outcome.Reason != "Remote host does not match regexp" {
+		t.Errorf("outcome %+v, want the denial bypassed", e.Outcome)
+	}
+	for _, e := range sink.find(audit.EventSessionStartup) {
+		if e.Session.BreakGlass {
+			t.Error("the startup event, emitted before break-glass, is tagged")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// A preparedQuery is the query behind a prepared statement or portal.
+type preparedQuery struct {
+	query     string
+	classes   []string
+	relations []audit.Relation
+	// Computed once needed for statistics
+	fingerprint string
+}
+
+// trackPrepared follows the statements and portals the client sets up,
+// so that later messages can be related to their query. g is nil if the
+// message was rejected. It must be called before the message is forwarded.
+func (p *ProxyConnection) trackPrepared(g *syncGroup, stmt *audit.Statement) {
+	switch stmt.Message {
+	case "Parse":
+		// A rejected statement must never be taken for the one that runs
+		if g == nil {
+			delete(p.prepared, stmt.PreparedStatement)
+			return
+		}
+		p.prepared[stmt.PreparedStatement] = &preparedQuery{
+			query:     stmt.Query,
+			classes:   stmt.Classes,
+			relations: stmt.Relations,
+		}
+
+	case "Bind":
+		if q := p.prepared[stmt.PreparedStatement]; q != nil {
+			p.portals[stmt.Portal] = q
+		} else {
+			delete(p.portals, stmt.Portal)
+		}
+
+	case "Close":
+		if stmt.Object == "prepared" {
+			delete(p.prepared, stmt.PreparedStatement)
+		} else {
+			delete(p.portals, stmt.Portal)
+		}
+
+	case "SimpleQuery":
+		// A simple Query replaces the unnamed statement and portal
+		delete(p.prepared, "")
+		delete(p.portals, "")
+	}
+}
+
+// classify returns the classes of the statements a message runs or refers
+// to. Messages such as Sync belong to no statement and have none.
+func (p *ProxyConnection) classify(stmt *audit.Statement) []string {
+	var q *preparedQuery
+	switch stmt.Message {
+	case "SimpleQuery", "Parse":
+		return query.QueryClasses(stmt.Query)
+	case "FunctionCall":
+		return []string{query.ClassFunction}
+	case "CopyData", "CopyDone", "CopyFail":
+		return []string{query.ClassCopy}
+	case "Bind":
+		q = p.prepared[stmt.PreparedStatement]
+	case "Execute":
+		q = p.portals[stmt.Portal]
+	case "Describe", "Close":
+		if stmt.Object == "prepared" {
+			q = p.prepared[stmt.PreparedStatement]
+		} else {
+			q = p.portals[stmt.Portal]
+		}
+	}
+	if q == nil {
+		return nil
+	}
+	return q.classes
+}
+
+// relations returns the relations referenced by the statements a message
+// runs: those of its query, or of the portal it executes. Queries the
+// PostgreSQL parser rejects have none.
+func (p *ProxyConnection) relations(stmt *audit.Statement) []audit.Relation {
+	switch stmt.Message {
+	case "SimpleQuery", "Parse":
+		found, err := query.Relations(stmt.Query)
+		if err != nil {
+			return nil
+		}
+		relations := make([]audit.Relation, len(found))
+		for i, r := range found {
+			relations[i] = audit.Relation{Schema: r.Schema, Name: r.Name, Access: r.Access}
+		}
+		return relations
+	case "Execute":
+		if q := p.portals[stmt.Portal]; q != nil {
+			return q.relations
+		}
+	}
+	return nil
+}
+
+// Audit levels, least verbose first.
+var classLogRank = map[string]int{
+	config.AuditLogNone:      0,
+	config.AuditLogStatement: 1,
+	config.AuditLogFull:      2,
+}
+
+// applyClasses applies the audit settings of the statement's classes,
+// the most verbose of them if it has several. It returns false if the
+// statement must not be audited. Statements that are rejected or fail to
+// parse are always audited.
+func (p *ProxyConnection) applyClasses(stmt *audit.Statement, outcome *audit.Outcome) bool {
+	if len(stmt.Classes) == 0 {
+		return true
+	}
+
+	level := config.AuditLogNone
+	for _, name := range stmt.Classes {
+		c := p.c.Audit.Class(name)
+		if classLogRank[c.Log] > classLogRank[level] {
+			level = c.Log
+		}
+		if c.Alert && outcome.Severity == "" {
+			outcome.Severity = audit.SeverityAlert
+		}
+	}
+
+	if outcome.Decision != audit.DecisionAllowed || outcome.Error != "" {
+		return true
+	}
+	switch level {
+	case config.AuditLogNone:
+		return false
+	case config.AuditLogStatement:
+		// Only the query text, or the function called
+		switch stmt.Message {
+		case "SimpleQuery", "Parse", "FunctionCall":
+			stmt.Args = nil
+			return true
+		}
+		return false
+	}
+	return true
+}
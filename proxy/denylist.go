@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// checkDenylist rejects query strings that use denied server-side
+// functions or commands, recording the match in outcome.
+func (p *ProxyConnection) checkDenylist(q string, outcome *audit.Outcome) *protocol.Error {
+	if !p.c.Denylist.Enabled {
+		return nil
+	}
+	denied := p.deniedUse(query.Split(q))
+	if denied == "" {
+		return nil
+	}
+	return deny(denied, outcome)
+}
+
+// checkDeniedOID rejects FunctionCall messages for denied functions.
+func (p *ProxyConnection) checkDeniedOID(oid int32, outcome *audit.Outcome) *protocol.Error {
+	if !p.c.Denylist.Enabled {
+		return nil
+	}
+	name, ok := p.c.Denylist.FunctionOIDs[oid]
+	if !ok {
+		return nil
+	}
+	return deny(name, outcome)
+}
+
+func (p *ProxyConnection) deniedUse(stmts []query.Statement) string {
+	for _, s := range stmts {
+		if s.Command() == "ALTER" && len(s.Tokens) > 1 && s.Tokens[1].Is("SYSTEM") {
+			return "ALTER SYSTEM"
+		}
+		switch s.CopyEndpoint() {
+		case query.CopyProgram:
+			return "COPY to or from a program"
+		case query.CopyFile:
+			return "COPY to or from a server file"
+		}
+		for _, fn := range s.FunctionCalls() {
+			if p.c.Denylist.DeniesFunction(fn) {
+				return fn
+			}
+		}
+		if denied := p.deniedUse(s.DoBodies()); denied != "" {
+			return denied
+		}
+	}
+	return ""
+}
+
+func deny(denied string, outcome *audit.Outcome) *protocol.Error {
+	outcome.Decision = audit.DecisionRejected
+	outcome.Reason = denied
+	outcome.Severity = audit.SeverityHigh
+
+	return &protocol.Error{
+		Severity: protocol.ErrorSeverityError,
+		Code:     protocol.ErrorCodeInsufficientPrivilege,
+		Message:  fmt.Sprintf("%s is not allowed through mammoth", denied),
+	}
+}
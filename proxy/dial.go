@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// How long resolving and dialing a backend may take, respectively.
+const (
+	backendResolveTimeout = 10 * time.Second
+	backendDialTimeout    = 10 * time.Second
+)
+
+// resolveBackend resolves host exactly once and returns the addresses that
+// pass the backend policy. Dialing these addresses (rather than the name)
+// guarantees that a DNS answer changing between the check and the dial
+// cannot point mammoth at a denied address.
+func (p *ProxyConnection) resolveBackend(host, port string) ([]net.IP, error) {
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid backend port %q", port)
+	}
+	if !p.c.Backends.PermitsPort(portNum) {
+		return nil, fmt.Errorf("Backend port %d is not allowed", portNum)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), backendResolveTimeout)
+		defer cancel()
+
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	// If any address is denied, refuse the host altogether rather than
+	// quietly falling back to the others: a name that resolves to a
+	// metadata address is suspicious in itself.
+	for _, ip := range ips {
+		if !p.c.Backends.PermitsIP(ip) {
+			p.log.Infof("Backend host %v resolved to disallowed address %v", host, ip)
+			return nil, fmt.Errorf("Backend address %v is not allowed", ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No addresses found for backend host %v", host)
+	}
+	return ips, nil
+}
+
+// dialBackend connects to the first reachable vetted address of host.
+func (p *ProxyConnection) dialBackend(host, port string) (net.Conn, error) {
+	ips, err := p.resolveBackend(host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), backendDialTimeout)
+		if err == nil {
+			return conn, nil
+		}
+		p.log.Debugf("Unable to connect to backend address %v: %v", ip, err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
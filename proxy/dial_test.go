@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/config/file"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func dialTestConnection(t *testing.T, f file.BackendConfig) *ProxyConnection {
+	c, err := config.FromFile(&file.Config{
+		Server:   file.ServerConfig{AllowUnencrypted: true},
+		Client:   file.ClientConfig{AllowUnencrypted: true},
+		Backends: f,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ProxyConnection{c: c, log: logrus.New()}
+}
+
+func TestResolveBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend file.BackendConfig
+		host    string
+		port    string
+		allowed bool
+	}{
+		{"public address", file.BackendConfig{}, "192.0.2.10", "5432", true},
+		{"public IPv6 address", file.BackendConfig{}, "2001:db8::10", "5432", true},
+		{"loopback", file.BackendConfig{}, "127.0.0.1", "5432", false},
+		{"loopback range", file.BackendConfig{}, "127.1.2.3", "5432", false},
+		{"IPv6 loopback", file.BackendConfig{}, "::1", "5432", false},
+		{"loopback name", file.BackendConfig{}, "localhost", "5432", false},
+		{"allowed loopback", file.BackendConfig{AllowLoopback: true}, "127.0.0.1", "5432", true},
+		{"allowed IPv6 loopback", file.BackendConfig{AllowLoopback: true}, "::1", "5432", true},
+		{"metadata endpoint", file.BackendConfig{AllowLoopback: true}, "169.254.169.254", "80", false},
+		{"IPv6 metadata endpoint", file.BackendConfig{}, "fd00:ec2::254", "80", false},
+		{"unspecified", file.BackendConfig{AllowLoopback: true}, "0.0.0.0", "5432", false},
+		{"IPv6 unspecified", file.BackendConfig{}, "::", "5432", false},
+		{"link-local", file.BackendConfig{}, "fe80::1", "5432", false},
+		{"IPv4-mapped metadata endpoint", file.BackendConfig{}, "::ffff:169.254.169.254", "80", false},
+		{"denied range", file.BackendConfig{Deny: []string{"10.0.0.0/8"}}, "10.1.2.3", "5432", false},
+		{"outside denied range", file.BackendConfig{Deny: []string{"10.0.0.0/8"}}, "192.0.2.10", "5432", true},
+		{"allowed range", file.BackendConfig{Allow: []string{"10.0.0.0/8"}}, "10.1.2.3", "5432", true},
+		{"outside allowed range", file.BackendConfig{Allow: []string{"10.0.0.0/8"}}, "192.0.2.10", "5432", false},
+		{"allow doesn't override builtin deny", file.BackendConfig{Allow: []string{"0.0.0.0/0"}}, "169.254.169.254", "80", false},
+		{"allowed port", file.BackendConfig{Ports: []int{5432}}, "192.0.2.10", "5432", true},
+		{"denied port", file.BackendConfig{Ports: []int{5432}}, "192.0.2.10", "22", false},
+		{"invalid port", file.BackendConfig{}, "192.0.2.10", "postgres", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := dialTestConnection(t, test.backend)
+			ips, err := p.resolveBackend(test.host, test.port)
+			if test.allowed && (err != nil || len(ips) == 0) {
+				t.Errorf("%v:%v: got %v, want it allowed", test.host, test.port, err)
+			}
+			if !test.allowed && err == nil {
+				t.Errorf("%v:%v: resolved to %v, want it denied", test.host, test.port, ips)
+			}
+		})
+	}
+}
+
+func TestDialBackendDenied(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	p := dialTestConnection(t, file.BackendConfig{})
+	if conn, err := p.dialBackend("127.0.0.1", port); err == nil {
+		conn.Close()
+		t.Fatal("dialed a loopback backend without allowLoopback")
+	}
+}
+
+// A cancel request goes to the address the session connected to, without
+// resolving the backend host again: the name might now point elsewhere.
+func TestSendCancelToSessionAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan pgproto3.FrontendMessage, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+		if err == nil {
+			received <- msg
+		}
+	}()
+
+	p := dialTestConnection(t, file.BackendConfig{})
+	if err := p.sendCancel("backend.invalid", ln.Addr().String(), 7, 42); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-received
+	if cancel, ok := msg.(*pgproto3.CancelRequest); !ok || cancel.ProcessID != 7 || cancel.SecretKey != 42 {
+		t.Errorf("backend received %#v, want a cancel request for pid 7", msg)
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+// How often a held statement checks whether auditing has recovered.
+const auditHoldInterval = 100 * time.Millisecond
+
+// Reason recorded for statements rejected while auditing is unavailable.
+const auditUnavailableReason = "audit unavailable"
+
+// failsClosed reports whether a message of type t must not reach the
+// backend unless it can be audited: those that run statements.
+func (p *ProxyConnection) failsClosed(t byte) bool {
+	if !p.policy.AuditFailClosed {
+		return false
+	}
+	switch t {
+	case protocol.SimpleQueryMessageType, protocol.ParseMessageType, protocol.BindMessageType,
+		protocol.ExecuteMessageType, protocol.FunctionCallMessageType:
+		return true
+	}
+	return false
+}
+
+// checkAudit holds a statement for up to the policy's hold timeout while
+// audit events can't be delivered, recording the outcome. It returns the
+// error to reject the statement with if auditing doesn't recover in time,
+// or nil if it may run.
+func (p *ProxyConnection) checkAudit(outcome *audit.Outcome) *protocol.Error {
+	err := p.auditor.Check()
+	if err != nil && p.policy.AuditHoldTimeout > 0 {
+		deadline := time.Now().Add(p.policy.AuditHoldTimeout)
+		for err != nil && time.Now().Before(deadline) && !p.closed.Load() {
+			time.Sleep(auditHoldInterval)
+			err = p.auditor.Check()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	p.log.Warnf("Statement rejected, auditing is unavailable: %v", err)
+	p.auditor.CountRejected()
+	outcome.Decision = audit.DecisionRejected
+	outcome.Reason = auditUnavailableReason
+	outcome.Error = err.Error()
+	return auditUnavailableError()
+}
+
+// rejectUnaudited rejects a statement whose audit event some sinks failed
+// to write. The sinks that did write it recorded the statement as allowed,
+// so it is audited again as rejected.
+func (p *ProxyConnection) rejectUnaudited(stmt *audit.Statement, auditErr error) *protocol.Error {
+	p.log.Warnf("Statement rejected, its audit event could not be written: %v", auditErr)
+	p.auditor.Check()
+	p.auditor.CountRejected()
+
+	e := auditUnavailableError()
+	p.audit(&audit.Event{
+		Type:      audit.EventStatement,
+		Statement: stmt,
+		Outcome: &audit.Outcome{
+			Decision: audit.DecisionRejected,
+			Reason:   auditUnavailableReason,
+			Error:    auditErr.Error(),
+			SQLState: e.Code,
+		},
+	})
+	return e
+}
+
+func auditUnavailableError() *protocol.Error {
+	return &protocol.Error{
+		Severity: protocol.ErrorSeverityError,
+		Code:     protocol.ErrorCodeSystemError,
+		Message:  "Statement rejected by mammoth: auditing is unavailable",
+		Hint:     "Retry once auditing has recovered.",
+	}
+}
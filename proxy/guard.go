@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/query"
+)
+
+// Comment that confirms a destructive statement is intended.
+const confirmMarker = "mammoth:confirm"
+
+// Rejected statements a session remembers, awaiting confirmation.
+const maxUnconfirmed = 100
+
+// confirmKey identifies a statement for confirmation, regardless of its
+// comments and whitespace.
+func confirmKey(q string) string {
+	var b strings.Builder
+	for _, t := range query.Lex(q) {
+		if t.Kind != query.Comment {
+			b.WriteString(t.Text)
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// checkDestructive applies the destructive statement guard to a query
+// string, recording the outcome. It returns the error to reject
+// the query with, or nil if it may run. The first attempt is always
+// rejected; only a repeat carrying the confirmation comment runs.
+func (p *ProxyConnection) checkDestructive(q string, outcome *audit.Outcome) *protocol.Error {
+	if !p.policy.GuardDestructive {
+		return nil
+	}
+
+	var reason string
+	for _, s := range query.Split(q) {
+		if reason = s.Destructive(); reason != "" {
+			break
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	outcome.Reason = reason
+	key := confirmKey(q)
+	if query.HasComment(q, confirmMarker) && p.unconfirmed[key] {
+		delete(p.unconfirmed, key)
+		outcome.Decision = audit.DecisionConfirmed
+		return nil
+	}
+	if len(p.unconfirmed) >= maxUnconfirmed {
+		p.unconfirmed = map[string]bool{}
+	}
+	p.unconfirmed[key] = true
+	outcome.Decision = audit.DecisionRejected
+
+	return &protocol.Error{
+		Severity: protocol.ErrorSeverityError,
+		Code:     protocol.ErrorCodeInsufficientPrivilege,
+		Message:  fmt.Sprintf("%s rejected by mammoth on a protected target", reason),
+		Hint:     fmt.Sprintf("If this is intended, repeat the statement with a /* %s */ comment.", confirmMarker),
+	}
+}
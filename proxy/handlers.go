@@ -5,19 +5,13 @@ import (
 	"encoding/hex"
 	"io"
 
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/protocol"
 )
 
-const typeField = "type"
-
-type pgArg struct {
-	Fmt   string
-	Value string
-}
-
 // See description of FunctionCall or Bind below for the type of data that
 // this function parses.
-func handleArgs(m *protocol.Reader) ([]pgArg, error) {
+func handleArgs(m *protocol.Reader) ([]audit.Arg, error) {
 	argFmtCount16, err := m.ReadInt16()
 	if err != nil {
 		return nil, err
@@ -41,7 +35,7 @@ func handleArgs(m *protocol.Reader) ([]pgArg, error) {
 	}
 	argCnt := int(argCnt16)
 
-	args := make([]pgArg, argCnt)
+	args := make([]audit.Arg, argCnt)
 
 	for i := 0; i < argCnt; i++ {
 		argLen, err := m.ReadInt32()
@@ -49,7 +43,7 @@ func handleArgs(m *protocol.Reader) ([]pgArg, error) {
 			return args[:i], err
 		}
 		if argLen == -1 {
-			args[i] = pgArg{Fmt: "null", Value: ""}
+			args[i] = audit.Arg{Format: "null", Value: ""}
 			continue
 		}
 
@@ -80,7 +74,7 @@ func handleArgs(m *protocol.Reader) ([]pgArg, error) {
 			argValue = hex.EncodeToString(argBuf)
 		}
 
-		args[i] = pgArg{Fmt: argFmt, Value: argValue}
+		args[i] = audit.Arg{Format: argFmt, Value: argValue}
 	}
 	return args, nil
 }
@@ -96,13 +90,13 @@ Length of message contents in bytes, including self.
 Byten
 Data that forms part of a COPY data stream. Messages sent from the backend will always correspond to single data rows, but messages sent by frontends might divide the data stream arbitrarily.
 */
-func handleCopyData(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "CopyData"
+func handleCopyData(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "CopyData"
 
 	buf := bytes.Buffer{}
 	_, err := io.Copy(&buf, m)
 
-	fields["value"] = hex.EncodeToString(buf.Bytes())
+	s.Data = hex.EncodeToString(buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -118,8 +112,8 @@ Identifies the message as a COPY-complete indicator.
 Int32(4)
 Length of message contents in bytes, including self.
 */
-func handleCopyDone(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "CopyDone"
+func handleCopyDone(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "CopyDone"
 	return m.Finalize()
 }
 
@@ -134,11 +128,11 @@ Length of message contents in bytes, including self.
 String
 An error message to report as the cause of failure.
 */
-func handleCopyFail(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "CopyFail"
+func handleCopyFail(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "CopyFail"
 
 	msg, err := m.ReadString()
-	fields["errorMessage"] = msg
+	s.ErrorMessage = msg
 	if err != nil {
 		return err
 	}
@@ -185,17 +179,23 @@ The number of result-column format codes that follow (denoted R below). This can
 Int16[R]
 The result-column format codes. Each must presently be zero (text) or one (binary).
 */
-func handleBind(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Bind"
+func handleBind(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Bind"
 
 	name, err := m.ReadString()
-	fields["portal"] = name
+	s.Portal = name
+	if err != nil {
+		return err
+	}
+
+	prepStmt, err := m.ReadString()
+	s.PreparedStatement = prepStmt
 	if err != nil {
 		return err
 	}
 
 	args, err := handleArgs(m)
-	fields["args"] = args
+	s.Args = args
 	if err != nil {
 		return err
 	}
@@ -217,24 +217,27 @@ Byte1
 String
 The name of the prepared statement or portal to close (an empty string selects the unnamed prepared statement or portal).
 */
-func handleClose(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Close"
+func handleClose(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Close"
 
-	target := "unknown"
-	targetB, err := m.ReadByte()
-	switch targetB {
+	s.Object = "unknown"
+	object, err := m.ReadByte()
+	switch object {
 	case 'S':
-		target = "prepared"
+		s.Object = "prepared"
 	case 'P':
-		target = "portal"
+		s.Object = "portal"
 	}
-	fields["target"] = target
 	if err != nil {
 		return err
 	}
 
 	name, err := m.ReadString()
-	fields["name"] = name
+	if object == 'P' {
+		s.Portal = name
+	} else {
+		s.PreparedStatement = name
+	}
 	if err != nil {
 		return err
 	}
@@ -256,24 +259,27 @@ Byte1
 String
 The name of the prepared statement or portal to describe (an empty string selects the unnamed prepared statement or portal).
 */
-func handleDescribe(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Describe"
+func handleDescribe(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Describe"
 
-	target := "unknown"
-	targetB, err := m.ReadByte()
-	switch targetB {
+	s.Object = "unknown"
+	object, err := m.ReadByte()
+	switch object {
 	case 'S':
-		target = "prepared"
+		s.Object = "prepared"
 	case 'P':
-		target = "portal"
+		s.Object = "portal"
 	}
-	fields["target"] = target
 	if err != nil {
 		return err
 	}
 
 	name, err := m.ReadString()
-	fields["name"] = name
+	if object == 'P' {
+		s.Portal = name
+	} else {
+		s.PreparedStatement = name
+	}
 	if err != nil {
 		return err
 	}
@@ -295,18 +301,18 @@ The name of the portal to execute (an empty string selects the unnamed portal).
 Int32
 Maximum number of rows to return, if portal contains a query that returns rows (ignored otherwise). Zero denotes “no limit”.
 */
-func handleExecute(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Execute"
+func handleExecute(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Execute"
 
 	portal, err := m.ReadString()
-	fields["portalName"] = portal
+	s.Portal = portal
 
 	if err != nil {
 		return err
 	}
 
 	maxRows, err := m.ReadInt32()
-	fields["maxRows"] = maxRows
+	s.MaxRows = maxRows
 	if err != nil {
 		return err
 	}
@@ -325,11 +331,11 @@ Length of message contents in bytes, including self.
 String
 The query string itself.
 */
-func handleSimpleQuery(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "SimpleQuery"
+func handleSimpleQuery(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "SimpleQuery"
 
-	s, err := m.ReadString()
-	fields["query"] = s
+	q, err := m.ReadString()
+	s.Query = q
 	if err != nil {
 		return err
 	}
@@ -359,17 +365,17 @@ Then, for each parameter, there is the following:
 Int32
 Specifies the object ID of the parameter data type. Placing a zero here is equivalent to leaving the type unspecified.
 */
-func handleParse(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Parse"
+func handleParse(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Parse"
 
 	prepStmt, err := m.ReadString()
-	fields["preparedStatement"] = prepStmt
+	s.PreparedStatement = prepStmt
 	if err != nil {
 		return err
 	}
 
 	query, err := m.ReadString()
-	fields["query"] = query
+	s.Query = query
 	if err != nil {
 		return err
 	}
@@ -410,17 +416,17 @@ After the last argument, the following field appears:
 Int16
 The format code for the function result. Must presently be zero (text) or one (binary).
 */
-func handleFunctionCall(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "FunctionCall"
+func handleFunctionCall(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "FunctionCall"
 
-	oid, err := m.ReadInt16()
-	fields["funcOID"] = oid
+	oid, err := m.ReadInt32()
+	s.FunctionOID = oid
 	if err != nil {
 		return err
 	}
 
 	args, err := handleArgs(m)
-	fields["args"] = args
+	s.Args = args
 	if err != nil {
 		return err
 	}
@@ -436,8 +442,8 @@ Identifies the message as a Sync command.
 Int32(4)
 Length of message contents in bytes, including self.
 */
-func handleSync(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Sync"
+func handleSync(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Sync"
 	return m.Finalize()
 }
 
@@ -449,7 +455,7 @@ Identifies the message as a termination.
 Int32(4)
 Length of message contents in bytes, including self.
 */
-func handleTerminate(m *protocol.Reader, fields map[string]interface{}) error {
-	fields[typeField] = "Terminate"
+func handleTerminate(m *protocol.Reader, s *audit.Statement) error {
+	s.Message = "Terminate"
 	return m.Finalize()
 }
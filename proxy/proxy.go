@@ -17,36 +17,63 @@ package proxy
 import (
 	"io"
 	"net"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/stats"
 	"github.com/brunopadz/mammoth/util/log"
 )
 
 type Proxy struct {
 	Config  *config.Config
 	Secrets *BackendSecrets
+	Quotas  *QuotaTracker
+	Auditor *audit.Auditor
+	// Nil unless statement statistics are enabled
+	Stats *stats.Collector
 }
 
-func NewProxy(c *config.Config) *Proxy {
+func NewProxy(c *config.Config, a *audit.Auditor, st *stats.Collector) *Proxy {
 	return &Proxy{
 		Config:  c,
 		Secrets: NewBackendSecrets(),
+		Quotas:  NewQuotaTracker(),
+		Auditor: a,
+		Stats:   st,
 	}
 }
 
 // HandleConnection handle an incoming connection to the proxy
 func (p *Proxy) HandleConnection(conn net.Conn) error {
+	id := audit.NewSessionID()
 	l := log.WithFields(logrus.Fields{
-		"client": conn.RemoteAddr().String(),
+		"client":  conn.RemoteAddr().String(),
+		"session": id,
 	})
 	l.Info("Accepting connection")
 
-	err := (&ProxyConnection{
-		c:       p.Config,
-		secrets: p.Secrets,
-		log:     l,
-	}).HandleConnection(conn)
+	pc := &ProxyConnection{
+		c:            p.Config,
+		secrets:      p.Secrets,
+		quotaTracker: p.Quotas,
+		auditor:      p.Auditor,
+		stats:        p.Stats,
+		prepared:     map[string]*preparedQuery{},
+		portals:      map[string]*preparedQuery{},
+		unconfirmed:  map[string]bool{},
+		log:          l,
+		accepted:     time.Now(),
+		session: &audit.Session{
+			ID:     id,
+			Client: conn.RemoteAddr().String(),
+		},
+	}
+	pc.audit(&audit.Event{Type: audit.EventSessionAccepted})
+
+	err := pc.HandleConnection(conn)
+	pc.auditClosed(err)
 
 	if err != nil && err != io.EOF {
 		l.Infof("Connection handling closed with error: %v", err)
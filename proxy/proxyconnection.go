@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bufio"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
@@ -9,20 +10,104 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/config"
 	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/record"
+	"github.com/brunopadz/mammoth/stats"
 )
 
 type ProxyConnection struct {
-	log     logrus.FieldLogger
-	c       *config.Config
-	secrets *BackendSecrets
+	log          logrus.FieldLogger
+	c            *config.Config
+	secrets      *BackendSecrets
+	quotaTracker *QuotaTracker
+	auditor      *audit.Auditor
+	stats        *stats.Collector
+	policy       config.Policy
+
+	clientAddr string
+	user       string
+	host       string
+	port       string
+	target     string
+	session    *audit.Session
+	accepted   time.Time
+
+	// The backend's own cancellation key, as opposed to the one handed
+	// to the client
+	backendPID    int32
+	backendSecret int32
+
+	// Set once the backend connection is established. Writes to either
+	// side go through writeClient/writeServer, since they are shared
+	// between the two pumps and the session watchdog.
+	clientConn net.Conn
+	serverConn net.Conn
+	clientW    *bufio.Writer
+	clientMtx  sync.Mutex
+	serverMtx  sync.Mutex
+
+	started       time.Time
+	lastClientMsg atomic.Int64
+	lastReady     atomic.Int64
+	txStatus      atomic.Int32
+	// Set while the transaction is failed by an error of mammoth's own,
+	// which the backend knows nothing about
+	txAborted atomic.Bool
+
+	// Counted by the client pump, which may still be running when the
+	// session is audited as closed
+	bytesFromClient atomic.Int64
+	statements      atomic.Int64
+
+	// Counts the statements the backend has completed, so that a row
+	// limit cancellation can tell whether its statement is still running
+	statementsDone atomic.Int64
+
+	// Only touched by the server pump
+	bytesToClient int64
+	rowCount      int
+	rowLimitHit   bool
+	copyOut       bool
+	callRows      int64
+	quotas        []*config.Quota
+	sessionQuotas *QuotaTracker
+
+	// Only touched by the client pump
+	openGroup *syncGroup
+	// Set from a rejection until the end of its batch
+	skipping bool
+	prepared map[string]*preparedQuery
+	portals  map[string]*preparedQuery
+	// Destructive statements rejected, by confirmKey
+	unconfirmed map[string]bool
+	recorder    *record.Writer
+	groupsMtx   sync.Mutex
+	groups      []*syncGroup
+
+	// Path of the session recording, if any
+	recording string
+
+	closeOnce   sync.Once
+	closed      atomic.Bool
+	terminating atomic.Bool
+
+	// Why the session ended, set by whichever goroutine finds out first
+	closeMtx    sync.Mutex
+	closeReason string
 }
 
-func parseStartupMessage(r *protocol.Reader) (host, port, user string, newStartupMessage *protocol.Buffer, e error) {
-	var database string
+// Startup option a client sets to request break-glass access. It is never
+// forwarded to the backend.
+const breakGlassReasonOption = "mammoth.reason"
+
+func parseStartupMessage(r *protocol.Reader) (host, port, database, user, reason string, newStartupMessage *protocol.Buffer, e error) {
 	props := map[string]string{}
 
 	for {
@@ -52,20 +137,23 @@ func parseStartupMessage(r *protocol.Reader) (host, port, user string, newStartu
 			database = val
 		} else if key == "user" {
 			user = val
+		} else if key == breakGlassReasonOption {
+			reason = strings.TrimSpace(val)
+		} else if key == "options" {
+			// libpq can't send arbitrary startup options, so the reason
+			// may also arrive as "-c mammoth.reason=..." in options
+			rest, optReason := extractOptionsReason(val)
+			if optReason != "" {
+				reason = strings.TrimSpace(optReason)
+			}
+			if rest != "" {
+				props[key] = rest
+			}
 		} else {
 			props[key] = val
 		}
 	}
 
-	users := load.LoadProhibitedUsers()
-
-	for _, v := range users {
-		if v == user {
-			e = errors.New("User not allowed")
-			return
-		}
-	}
-
 	if database == "" {
 		e = errors.New("database field empty")
 		return
@@ -86,7 +174,8 @@ func parseStartupMessage(r *protocol.Reader) (host, port, user string, newStartu
 		port = "5432"
 	}
 
-	props["database"] = split[1]
+	database = split[1]
+	props["database"] = database
 	props["user"] = user
 
 	newStartupMessage = protocol.NewBuffer()
@@ -99,6 +188,15 @@ func parseStartupMessage(r *protocol.Reader) (host, port, user string, newStartu
 	return
 }
 
+func isProhibitedUser(user string) bool {
+	for _, v := range load.LoadProhibitedUsers() {
+		if v == user {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *ProxyConnection) TrySSLUpgrade(conn net.Conn) (net.Conn, error) {
 	if p.c.Server.BaseTLSConfig != nil {
 		p.log.Debug("Upgrading SSL connection")
@@ -119,6 +217,7 @@ func (p *ProxyConnection) TrySSLUpgrade(conn net.Conn) (net.Conn, error) {
 
 func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 	defer clientConn.Close()
+	p.clientAddr = clientConn.RemoteAddr().String()
 
 	r, err := protocol.ReadMessage(clientConn)
 	if err != nil {
@@ -145,6 +244,7 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 			p.log.Infof("Error performing SSL handshake: %w")
 			return err
 		}
+		p.auditTLS(clientConn)
 		/*
 		 * Re-read the startup message from the client. It is possible that the
 		 * client might not like the response given and as a result it might
@@ -171,6 +271,7 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 		// For this reason, we accept cancel requests even if it's not SSL
 		if p.c.Server.BaseTLSConfig != nil && p.c.Server.AllowUnencrypted == false {
 			p.log.Infof("Rejecting client without SSL because allowUnecrypted is false (version = %v)", version)
+			p.setCloseReason("client without SSL")
 			return nil
 		}
 	}
@@ -187,36 +288,20 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 		if err := r.Finalize(); err != nil {
 			return err
 		}
+		p.setCloseReason("cancel request")
 		s, ok := p.secrets.Get(pid, secret)
 		if !ok {
 			return nil
 		}
 
-		p.log.Debug("Connecting to backend for cancellation")
-		serverConn, err := p.ConnectBackend(s.host, s.port)
-		if err != nil {
-			p.log.Infof("Unable to connect to backend for cancellation %v:%v: %v", s.host, s.port, err)
-		}
-		defer serverConn.Close()
-
-		msg := protocol.NewBuffer()
-		msg.WriteInt32(protocol.CancelRequestCode)
-		msg.WriteInt32(pid)
-		msg.WriteInt32(s.origSecret)
-		if err := msg.WriteTo(serverConn); err != nil {
-			return err
-		}
-
-		p.log.Infof("Successfully sent cancellation to %v:%v, pid %v", s.host, s.port, pid)
-		// In theory, the server should drop the connection immediately after, so
-		// we don't await a response, and neither should the client.
-		return nil
+		return p.sendCancel(s.host, s.addr, pid, s.origSecret)
 	} else if version != protocol.ProtocolVersion {
 		p.log.Infof("Unsupported protocol version from client: %v", version)
+		p.setCloseReason("unsupported protocol version")
 		return nil
 	}
 
-	host, port, user, newStartupMessage, err := parseStartupMessage(r)
+	host, port, database, user, reason, newStartupMessage, err := parseStartupMessage(r)
 	if err != nil {
 		p.log.Infof("Unable to parse startup message from client: %v", err)
 		protocol.WriteError(clientConn, protocol.Error{
@@ -232,21 +317,71 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 		"user":   user,
 		"server": net.JoinHostPort(host, port),
 	})
+	p.user, p.host, p.port = user, host, port
+	p.target = p.targetName()
+	p.session.User = user
+	p.session.Server = net.JoinHostPort(host, port)
+	p.session.Database = database
+	p.session.Target = p.target
+	p.audit(&audit.Event{Type: audit.EventSessionStartup})
+
+	var denial string
+	if isProhibitedUser(user) {
+		p.log.Infof("User %v is blocked", user)
+		denial = "User not allowed"
+	} else if p.c.HostRegex != nil && !p.c.HostRegex.MatchString(host) {
+		p.log.Infof("Backend host %v does not match regexp %v", host, p.c.HostRegex)
+		denial = "Remote host does not match regexp"
+	}
+
+	if denial != "" {
+		if reason == "" || !p.c.BreakGlass.Permits(user, reason) {
+			hint := ""
+			if reason != "" {
+				p.log.Infof("Break-glass access refused for user %v", user)
+				hint = "Break-glass access is not available to this user or the reason is too short"
+			}
+			protocol.WriteError(clientConn, protocol.Error{
+				Severity: protocol.ErrorSeverityFatal,
+				Code:     protocol.ErrorCodeConnectionFailure,
+				Message:  denial,
+				Hint:     hint,
+			})
+			p.auditPolicy(audit.DecisionRejected, denial)
+			return nil
+		}
+		p.startBreakGlass(reason, denial)
+	}
 
-	if p.c.HostRegex != nil && !p.c.HostRegex.MatchString(host) {
-		p.log.Infof("Backend host %v does not match regexp %v", host)
+	if t := p.c.TargetFor(host); t != nil && !t.Access.Permits(config.AddrIP(clientConn.RemoteAddr())) {
+		p.log.Infof("Client address %v is not allowed to reach target %v", clientConn.RemoteAddr(), t.Name)
 		protocol.WriteError(clientConn, protocol.Error{
 			Severity: protocol.ErrorSeverityFatal,
-			Code:     protocol.ErrorCodeConnectionFailure,
-			Message:  "Remote host does not match regexp",
+			Code:     protocol.ErrorCodeServerRejected,
+			Message:  "Client address not allowed for this target",
 		})
+		p.auditPolicy(audit.DecisionRejected, "Client address not allowed for this target")
 		return nil
 	}
+	// A break-glass session records the denial it bypassed
+	p.auditPolicy(audit.DecisionAllowed, denial)
+
+	p.policy = p.c.PolicyFor(host)
+	for _, q := range p.c.Quotas {
+		if q.Applies(user, p.target) {
+			p.quotas = append(p.quotas, q)
+		}
+	}
+	p.sessionQuotas = NewQuotaTracker()
 
 	p.log.Debug("Connecting to backend")
 	serverConn, err := p.ConnectBackend(host, port)
 	if err != nil {
 		p.log.Infof("Unable to connect to backend %v:%v: %v", host, port, err)
+		p.audit(&audit.Event{
+			Type:    audit.EventSessionBackend,
+			Outcome: &audit.Outcome{Decision: audit.DecisionRejected, Error: err.Error()},
+		})
 		protocol.WriteError(clientConn, protocol.Error{
 			Severity: protocol.ErrorSeverityFatal,
 			Code:     protocol.ErrorCodeClientUnableToConnect,
@@ -256,6 +391,11 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 		return err
 	}
 	defer serverConn.Close()
+	p.audit(&audit.Event{
+		Type:       audit.EventSessionBackend,
+		Connection: &audit.Connection{Backend: serverConn.RemoteAddr().String()},
+		Outcome:    &audit.Outcome{Decision: audit.DecisionAllowed},
+	})
 
 	err = newStartupMessage.WriteTo(serverConn)
 	if err != nil {
@@ -269,39 +409,57 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 		return err
 	}
 
+	p.clientConn = clientConn
+	p.serverConn = serverConn
+	p.clientW = bufio.NewWriter(clientConn)
+	p.started = time.Now()
+
+	p.startRecording()
+
 	p.log.Debug("Passing through data between client and server")
 	clientDone := make(chan bool)
 	go func() {
-		err := p.PassthruAndLog(serverConn, clientConn)
-		if err != nil && err != io.EOF {
+		err := p.PassthruAndLog(bufio.NewReader(clientConn))
+		if err != nil && err != io.EOF && !p.closed.Load() && !p.terminating.Load() {
 			p.log.Infof("Client closed with error: %v", err)
 		}
+		p.stopRecording()
+		p.close("client disconnected")
 		close(clientDone)
 	}()
 
-	pid, secret, added, err := p.PassthruAndRewriteBackendData(clientConn, serverConn, host, port)
+	pid, secret, added, err := p.PassthruAndRewriteBackendData(clientConn, serverConn, host)
 	if added {
 		defer p.secrets.Remove(pid, secret)
 	}
 	if err != nil {
+		// The client pump must be done with the session before it is
+		// audited as closed
+		p.close("backend closed during startup")
+		<-clientDone
 		return err
 	}
+	p.markReady(protocol.TxStatusIdle)
 
 	// start pass-thru copy
 	serverDone := make(chan bool)
 	go func() {
-		_, err := io.Copy(clientConn, serverConn)
-		if err != nil {
+		err := p.PassthruServerData(bufio.NewReader(serverConn))
+		if err != nil && err != io.EOF && !p.closed.Load() && !p.terminating.Load() {
 			p.log.Infof("Server closed with error: %v", err)
 		}
+		p.close("server disconnected")
 		close(serverDone)
 	}()
 
-	// TODO[tmw]: Can these hang?
+	watchdogDone := make(chan struct{})
+	go p.watchdog(watchdogDone)
+
 	<-clientDone
 	<-serverDone
+	close(watchdogDone)
 
-	p.log.Infof("Client disconnected")
+	p.log.Infof("Client disconnected: %s", p.getCloseReason())
 
 	return nil
 }
@@ -312,8 +470,10 @@ func (p *ProxyConnection) HandleConnection(clientConn net.Conn) error {
 // that will be written to the client. In this way, we can handle cancellation.
 // Stops copying data after the first ReadyForQuery message is received,
 // which indicates that no further BackendDataPacket will be forthcoming.
-func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn net.Conn, host, port string) (pid int32, secret int32, added bool, err error) {
+// The outcome of authentication is audited along the way.
+func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn net.Conn, host string) (pid int32, secret int32, added bool, err error) {
 	msgTypeBuf := make([]byte, 1)
+	authenticated := false
 
 	for {
 		_, err = serverConn.Read(msgTypeBuf)
@@ -332,6 +492,7 @@ func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn n
 		if err != nil {
 			return
 		}
+		p.bytesToClient += int64(msg.Len) + 1
 
 		if msgType == protocol.BackendKeyDataMessageType {
 			pid, err = msg.ReadInt32()
@@ -347,7 +508,8 @@ func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn n
 			if added {
 				p.secrets.Remove(pid, secret)
 			}
-			secret = p.secrets.Add(pid, secret, host, port)
+			p.backendPID, p.backendSecret = pid, secret
+			secret = p.secrets.Add(pid, secret, host, serverConn.RemoteAddr().String())
 			added = true
 
 			msgOut := protocol.NewBuffer()
@@ -359,11 +521,20 @@ func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn n
 				return
 			}
 		} else {
+			var body []byte
+			body, err = io.ReadAll(msg)
+			if err != nil {
+				return
+			}
+			if !authenticated {
+				authenticated = p.auditAuth(&protocol.Message{Type: msgType, Body: body})
+			}
+
 			err = binary.Write(clientConn, binary.BigEndian, msg.Len)
 			if err != nil {
 				return
 			}
-			_, err = io.Copy(clientConn, msg)
+			_, err = clientConn.Write(body)
 			if err != nil {
 				return
 			}
@@ -376,90 +547,253 @@ func (p *ProxyConnection) PassthruAndRewriteBackendData(clientConn, serverConn n
 }
 
 // Parses all packets coming from the client conn to the server conn,
-// and logs all relevant commands to the logger
-func (p *ProxyConnection) PassthruAndLog(serverConn, clientConn net.Conn) error {
-	msgTypeBuf := make([]byte, 1)
-
-	// Every byte that's read out of the tee will be sent straight to
-	// the postgres server
-	tee := io.TeeReader(clientConn, serverConn)
+// and emits an audit event for each of them
+func (p *ProxyConnection) PassthruAndLog(clientR *bufio.Reader) error {
 	for {
-		_, err := tee.Read(msgTypeBuf)
+		raw, err := protocol.ReadTypedMessage(clientR)
 		if err != nil {
 			return err
 		}
-		msgType := msgTypeBuf[0]
+		p.lastClientMsg.Store(time.Now().UnixNano())
+		p.bytesFromClient.Add(int64(raw.Len()))
 
-		msg, err := protocol.ReadMessage(tee)
-		if err != nil {
-			return err
-		}
-
-		fields := logrus.Fields{}
+		msgType := raw.Type
+		msg := raw.Reader()
+		stmt := &audit.Statement{}
+		outcome := &audit.Outcome{Decision: audit.DecisionAllowed}
 
 		switch msgType {
 		case protocol.BindMessageType:
-			err = handleBind(msg, fields)
+			err = handleBind(msg, stmt)
 
 		case protocol.CloseMessageType:
-			err = handleClose(msg, fields)
+			err = handleClose(msg, stmt)
 
 		case protocol.CopyDataMessageType:
-			err = handleCopyData(msg, fields)
+			err = handleCopyData(msg, stmt)
 
 		case protocol.CopyDoneMessageType:
-			err = handleCopyDone(msg, fields)
+			err = handleCopyDone(msg, stmt)
 
 		case protocol.CopyFailMessageType:
-			err = handleCopyFail(msg, fields)
+			err = handleCopyFail(msg, stmt)
 
 		case protocol.DescribeMessageType:
-			err = handleDescribe(msg, fields)
+			err = handleDescribe(msg, stmt)
 
 		case protocol.ExecuteMessageType:
-			err = handleExecute(msg, fields)
+			err = handleExecute(msg, stmt)
 
 		case protocol.FunctionCallMessageType:
-			err = handleFunctionCall(msg, fields)
+			err = handleFunctionCall(msg, stmt)
 
 		case protocol.ParseMessageType:
-			err = handleParse(msg, fields)
+			err = handleParse(msg, stmt)
 
 		case protocol.SimpleQueryMessageType:
-			err = handleSimpleQuery(msg, fields)
+			err = handleSimpleQuery(msg, stmt)
 
 		case protocol.SyncMessageType:
-			err = handleSync(msg, fields)
+			err = handleSync(msg, stmt)
 
 		case protocol.TerminateMessageType:
-			err = handleTerminate(msg, fields)
+			err = handleTerminate(msg, stmt)
 
 		default:
-			fields["type"] = "Unknown"
-			fields["code"] = int(msgType)
-			fields["len"] = msg.Len
+			stmt.Message = "Unknown"
+			stmt.Code = int(msgType)
+			stmt.Length = msg.Len
 			err = msg.Discard()
 		}
 
 		if err != nil && err != io.EOF {
-			fields["ioerror"] = err.Error()
+			outcome.Error = err.Error()
+		}
+
+		// The rest of a batch is skipped after a rejection, up to Sync
+		skipped := p.skipping && msgType != protocol.SyncMessageType && msgType != protocol.TerminateMessageType
+		if skipped {
+			outcome.Decision = audit.DecisionRejected
+			outcome.Reason = skippedReason
+		}
+
+		var rejection *protocol.Error
+		if err == nil && !skipped {
+			switch msgType {
+			case protocol.SimpleQueryMessageType, protocol.ParseMessageType:
+				rejection = p.checkAborted(raw, stmt, outcome)
+				if rejection == nil {
+					rejection = p.checkDenylist(stmt.Query, outcome)
+				}
+				if rejection == nil {
+					rejection = p.checkDestructive(stmt.Query, outcome)
+				}
+			case protocol.FunctionCallMessageType:
+				rejection = p.checkAborted(raw, stmt, outcome)
+				if rejection == nil {
+					rejection = p.checkDeniedOID(stmt.FunctionOID, outcome)
+				}
+			}
+			if rejection == nil && p.failsClosed(msgType) {
+				rejection = p.checkAudit(outcome)
+			}
+		}
+		if rejection != nil {
+			outcome.SQLState = rejection.Code
+		}
+		switch msgType {
+		case protocol.SimpleQueryMessageType, protocol.ExecuteMessageType, protocol.FunctionCallMessageType:
+			if !skipped {
+				p.statements.Add(1)
+			}
+		}
+
+		stmt.Classes = p.classify(stmt)
+		stmt.Relations = p.relations(stmt)
+		if p.applyClasses(stmt, outcome) {
+			auditErr := p.audit(&audit.Event{
+				Type:      audit.EventStatement,
+				Statement: stmt,
+				Outcome:   outcome,
+			})
+			// Not every sink has a record of the statement
+			if auditErr != nil && err == nil && rejection == nil && p.failsClosed(msgType) {
+				rejection = p.rejectUnaudited(stmt, auditErr)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		// g is the sync group of raw if it is forwarded as part of one
+		var g *syncGroup
+		forward := true
+		switch {
+		case rejection != nil:
+			err = p.reject(raw, *rejection)
+			forward = false
+		case p.skipping && msgType != protocol.TerminateMessageType:
+			g, err = p.skip(raw)
+			forward = g != nil
+		case inSyncGroup(msgType):
+			g = p.trackSyncGroup(raw)
+		}
+		if err != nil {
+			return err
+		}
+		p.recordMessage(raw, !forward)
+		if !skipped {
+			p.trackStatement(g, stmt)
+			p.trackPrepared(g, stmt)
+			p.trackCall(g, stmt)
+		}
+		if !forward {
+			continue
+		}
+		if err := p.writeServer(raw); err != nil {
+			return err
+		}
+		if msgType == protocol.TerminateMessageType {
+			p.close("client disconnected")
+			return nil
+		}
+	}
+}
+
+// Copies all packets coming from the server conn to the client conn once
+// the session is established, keeping track of the transaction status
+// reported by each ReadyForQuery.
+func (p *ProxyConnection) PassthruServerData(serverR *bufio.Reader) error {
+	for {
+		msg, err := protocol.ReadTypedMessage(serverR)
+		if err != nil {
+			return err
+		}
+
+		// Only flush once we've caught up with the server, so that bursts
+		// of small messages (e.g. DataRows) are batched into few writes
+		flush := serverR.Buffered() == 0
+		p.completeCalls(msg)
+		forward, err := p.limitRows(msg, flush)
+		if err == nil {
+			err = p.rewriteServerMessage(msg)
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Type == protocol.ReadyForQueryMessageType && len(msg.Body) == 1 {
+			p.markReady(msg.Body[0])
+		}
+		if !forward {
+			continue
+		}
+		if msg.Type == protocol.ErrorMessageType {
+			p.auditBackendError(msg)
 		}
 
-		p.log.WithFields(fields).Info("Command")
+		p.bytesToClient += int64(msg.Len())
+		delay := p.accountBytes(msg.Len())
+		err = p.writeClient(msg.Encode, flush || delay > 0)
+		if err == nil && msg.Type == protocol.ReadyForQueryMessageType {
+			err = p.retireGroup()
+		}
 		if err != nil {
 			return err
 		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
 	}
 }
 
+// How long the backend may take to process a cancel request.
+const cancelWaitTimeout = 5 * time.Second
+
+// sendCancel asks the backend to cancel the query running for the given
+// backend key. It dials addr, the address the session connected to, rather
+// than resolving host again: host only serves to verify the backend's
+// certificate.
+func (p *ProxyConnection) sendCancel(host, addr string, pid, secret int32) error {
+	p.log.Debug("Connecting to backend for cancellation")
+	serverConn, err := net.DialTimeout("tcp", addr, backendDialTimeout)
+	if err == nil {
+		serverConn, err = p.upgradeBackend(serverConn, host)
+	}
+	if err != nil {
+		p.log.Infof("Unable to connect to backend for cancellation %v: %v", addr, err)
+		return err
+	}
+	defer serverConn.Close()
+
+	msg := protocol.NewBuffer()
+	msg.WriteInt32(protocol.CancelRequestCode)
+	msg.WriteInt32(pid)
+	msg.WriteInt32(secret)
+	if err := msg.WriteTo(serverConn); err != nil {
+		return err
+	}
+
+	// The server doesn't answer, but only drops the connection once it has
+	// signalled the backend
+	serverConn.SetReadDeadline(time.Now().Add(cancelWaitTimeout))
+	io.Copy(io.Discard, serverConn)
+
+	p.log.Infof("Successfully sent cancellation to %v, pid %v", addr, pid)
+	return nil
+}
+
 func (p *ProxyConnection) ConnectBackend(host, port string) (net.Conn, error) {
-	hostPort := net.JoinHostPort(host, port)
-	conn, err := net.Dial("tcp", hostPort)
+	conn, err := p.dialBackend(host, port)
 
 	if err != nil {
 		return nil, err
 	}
+	return p.upgradeBackend(conn, host)
+}
 
+// upgradeBackend attempts to upgrade a new connection to the backend host
+// to SSL, if configured to.
+func (p *ProxyConnection) upgradeBackend(conn net.Conn, host string) (net.Conn, error) {
 	if !p.c.Client.TrySSL {
 		return conn, nil
 	}
@@ -476,7 +810,7 @@ func (p *ProxyConnection) ConnectBackend(host, port string) (net.Conn, error) {
 	/* Create the SSL request message. */
 	message := protocol.NewBuffer()
 	message.WriteInt32(protocol.SSLRequestCode)
-	err = message.WriteTo(conn)
+	err := message.WriteTo(conn)
 
 	if err != nil {
 		return nil, fmt.Errorf("Error writing to backend: %w", err)
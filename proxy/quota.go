@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config"
+)
+
+// QuotaTracker accumulates the data volume sent to clients per quota key,
+// across all sessions of the proxy.
+type QuotaTracker struct {
+	mtx       sync.Mutex
+	usage     map[string]*quotaUsage
+	lastSweep time.Time
+}
+
+// How often usage of past windows is dropped.
+const quotaSweepInterval = time.Minute
+
+type quotaUsage struct {
+	window   time.Time
+	expires  time.Time
+	bytes    int64
+	exceeded bool
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		usage: make(map[string]*quotaUsage),
+	}
+}
+
+// Add accounts n bytes against key for quota q. It returns the volume used
+// in the current window, and whether this call pushed it over the limit.
+func (t *QuotaTracker) Add(q *config.Quota, key string, n int64, now time.Time) (int64, bool) {
+	var window time.Time
+	if q.Window > 0 {
+		window = now.Truncate(q.Window)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if now.Sub(t.lastSweep) > quotaSweepInterval {
+		for k, u := range t.usage {
+			if !u.expires.IsZero() && now.After(u.expires) {
+				delete(t.usage, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	u, ok := t.usage[key]
+	if !ok || !u.window.Equal(window) {
+		u = &quotaUsage{window: window}
+		if q.Window > 0 {
+			u.expires = window.Add(q.Window)
+		}
+		t.usage[key] = u
+	}
+	u.bytes += n
+
+	crossed := false
+	if u.bytes > q.Limit && !u.exceeded {
+		u.exceeded = true
+		crossed = true
+	}
+	return u.bytes, crossed
+}
+
+// targetName is how the session's backend is referred to in quotas: the
+// name of the target it matched, or its address.
+func (p *ProxyConnection) targetName() string {
+	if t := p.c.TargetFor(p.host); t != nil {
+		return t.Name
+	}
+	return p.host + ":" + p.port
+}
+
+// accountBytes charges n bytes sent to the client against every quota
+// that applies to the session, and acts on those that are exceeded. It
+// returns how long the session should be throttled for.
+func (p *ProxyConnection) accountBytes(n int) time.Duration {
+	now := time.Now()
+	var delay time.Duration
+
+	for _, q := range p.quotas {
+		tracker := p.quotaTracker
+		if q.Scope == config.QuotaScopeSession {
+			tracker = p.sessionQuotas
+		}
+		used, crossed := tracker.Add(q, q.Key(p.user, p.target), int64(n), now)
+		if used <= q.Limit {
+			continue
+		}
+
+		if crossed {
+			p.log.WithFields(logrus.Fields{
+				"quota":       q.Name,
+				"quotaScope":  q.Scope,
+				"quotaLimit":  q.Limit,
+				"quotaUsed":   used,
+				"quotaAction": q.Action,
+			}).Warn("Data volume quota exceeded")
+			p.audit(&audit.Event{
+				Type: audit.EventLimit,
+				Limit: &audit.Limit{
+					Kind:   "quota",
+					Name:   q.Name,
+					Scope:  q.Scope,
+					Limit:  q.Limit,
+					Used:   used,
+					Action: q.Action,
+				},
+			})
+		}
+
+		switch q.Action {
+		case config.QuotaActionThrottle:
+			if d := time.Duration(int64(n) * int64(time.Second) / q.ThrottleRate); d > delay {
+				delay = d
+			}
+		case config.QuotaActionTerminate:
+			p.terminate("data volume quota exceeded",
+				"terminating connection because the data volume quota was exceeded")
+		}
+	}
+	return delay
+}
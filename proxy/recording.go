@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"path/filepath"
+
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/record"
+)
+
+// startRecording starts recording the session if its policy asks for it.
+// A session that can't be recorded goes ahead unrecorded.
+func (p *ProxyConnection) startRecording() {
+	if !p.policy.Record {
+		return
+	}
+
+	path := filepath.Join(p.c.Recording.Dir, p.session.ID+record.Ext)
+	w, err := record.Create(path, record.Header{
+		Session:  p.session.ID,
+		Client:   p.session.Client,
+		User:     p.session.User,
+		Server:   p.session.Server,
+		Database: p.session.Database,
+		Target:   p.session.Target,
+	})
+	if err != nil {
+		p.log.Errorf("Unable to record session: %v", err)
+		return
+	}
+	p.log.Debugf("Recording session to %s", path)
+	p.recorder = w
+	p.recording = path
+}
+
+// recordMessage adds a message from the client to the recording, as the
+// client sent it. Passwords and other authentication messages are never
+// recorded. Only the client pump may call it.
+func (p *ProxyConnection) recordMessage(m *protocol.Message, rejected bool) {
+	if p.recorder == nil || m.Type == protocol.PasswordMessageType {
+		return
+	}
+
+	var flags byte
+	if rejected {
+		flags |= record.FlagRejected
+	}
+	err := p.recorder.Write(m, flags)
+	if err == nil {
+		switch m.Type {
+		case protocol.SimpleQueryMessageType,
+			protocol.FunctionCallMessageType,
+			protocol.SyncMessageType,
+			protocol.TerminateMessageType:
+			// Flush whenever the client waits for the backend, so that
+			// little is lost if mammoth goes down
+			err = p.recorder.Flush()
+		}
+	}
+	if err != nil {
+		p.log.Errorf("Error recording session, recording stopped: %v", err)
+		p.stopRecording()
+	}
+}
+
+// stopRecording closes the recording, if any.
+func (p *ProxyConnection) stopRecording() {
+	if p.recorder == nil {
+		return
+	}
+	if err := p.recorder.Close(); err != nil {
+		p.log.Errorf("Error closing session recording: %v", err)
+	}
+	p.recorder = nil
+}
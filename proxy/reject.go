@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+// Reason recorded for the messages skipped after a rejection.
+const skippedReason = "skipped after a rejected message"
+
+// A syncGroup covers the client messages answered by a single
+// ReadyForQuery: a simple Query or FunctionCall, or an extended-protocol
+// batch ended by Sync. Groups are queued as soon as their first message
+// arrives, so answers always reach the client in order, whether they come
+// from the backend or from mammoth.
+type syncGroup struct {
+	// Error mammoth answers a rejected message of the group with, until
+	// it is sent
+	rejection *protocol.Error
+	// Some of the group's messages reached the backend, which then
+	// answers the group with its own ReadyForQuery
+	forwarded bool
+	// The backend reported an error for the group, and skipped the rest
+	// of it
+	failed bool
+	// mammoth sent the client an error of its own for the group, which
+	// fails the transaction as far as the client is concerned
+	errored bool
+	// The client ended the group
+	synced bool
+	// Executes forwarded that the backend has yet to complete
+	executes int
+	// Statements executed in the group, for statistics
+	calls []*call
+	// The statement errors of the backend for the group are audited
+	// against, see trackStatement
+	stmt *audit.Statement
+}
+
+// openSyncGroup returns the open sync group, opening a new one if needed.
+func (p *ProxyConnection) openSyncGroup() *syncGroup {
+	if p.openGroup != nil {
+		return p.openGroup
+	}
+	g := &syncGroup{}
+	p.groupsMtx.Lock()
+	p.groups = append(p.groups, g)
+	p.groupsMtx.Unlock()
+	return g
+}
+
+// endsSyncGroup reports whether a message of type t is answered by a
+// ReadyForQuery.
+func endsSyncGroup(t byte) bool {
+	switch t {
+	case protocol.SimpleQueryMessageType, protocol.FunctionCallMessageType, protocol.SyncMessageType:
+		return true
+	}
+	return false
+}
+
+// inSyncGroup reports whether a message of type t is answered as part of a
+// sync group. Authentication messages are answered during startup, COPY
+// data as part of the statement that started the COPY, and Terminate not
+// at all.
+func inSyncGroup(t byte) bool {
+	switch t {
+	case protocol.PasswordMessageType, protocol.CopyDataMessageType, protocol.CopyDoneMessageType,
+		protocol.CopyFailMessageType, protocol.TerminateMessageType:
+		return false
+	}
+	return true
+}
+
+// trackSyncGroup adds m to the open sync group, opening a new one if
+// needed. It must be called before m is forwarded.
+func (p *ProxyConnection) trackSyncGroup(m *protocol.Message) *syncGroup {
+	g := p.openSyncGroup()
+	p.groupsMtx.Lock()
+	g.forwarded = true
+	if m.Type == protocol.ExecuteMessageType {
+		g.executes++
+	}
+	p.groupsMtx.Unlock()
+
+	if endsSyncGroup(m.Type) {
+		p.openGroup = nil
+	} else {
+		p.openGroup = g
+	}
+	return g
+}
+
+// reject answers m with e instead of forwarding it. As the backend would,
+// mammoth then skips the rest of an extended-protocol batch until Sync,
+// see skip.
+func (p *ProxyConnection) reject(m *protocol.Message, e protocol.Error) error {
+	g := p.openSyncGroup()
+	p.groupsMtx.Lock()
+	if g.rejection == nil {
+		g.rejection = &e
+	}
+	if endsSyncGroup(m.Type) {
+		g.synced = true
+	}
+	p.groupsMtx.Unlock()
+
+	if endsSyncGroup(m.Type) {
+		p.openGroup = nil
+	} else {
+		p.openGroup = g
+		p.skipping = true
+	}
+	return p.answerGroups()
+}
+
+// skip drops a message following a rejection in the same batch. Sync ends
+// the batch: it returns the group if the backend has seen part of the
+// batch, in which case Sync must be forwarded for the backend to answer
+// it, or nil if mammoth answers the whole batch.
+func (p *ProxyConnection) skip(m *protocol.Message) (*syncGroup, error) {
+	if m.Type != protocol.SyncMessageType {
+		return nil, nil
+	}
+	g := p.openGroup
+	p.openGroup = nil
+	p.skipping = false
+
+	p.groupsMtx.Lock()
+	g.synced = true
+	forwarded := g.forwarded
+	p.groupsMtx.Unlock()
+
+	if forwarded {
+		return g, nil
+	}
+	return nil, p.answerGroups()
+}
+
+// answerGroups answers the sync groups that never reached the backend,
+// once the groups queued before them have been answered: with their
+// rejection as soon as possible, and with a ReadyForQuery once the client
+// has ended them, see readyStatus.
+func (p *ProxyConnection) answerGroups() error {
+	p.groupsMtx.Lock()
+	defer p.groupsMtx.Unlock()
+
+	for len(p.groups) > 0 {
+		g := p.groups[0]
+		if g.forwarded {
+			return nil
+		}
+		if g.rejection != nil {
+			e := *g.rejection
+			g.rejection = nil
+			g.errored = true
+			if err := p.writeClient(func(w io.Writer) error {
+				return protocol.WriteError(w, e)
+			}, true); err != nil {
+				return err
+			}
+		}
+		if !g.synced {
+			return nil
+		}
+
+		status := p.readyStatus(byte(p.txStatus.Load()), g.errored)
+		ready := protocol.NewBuffer()
+		ready.WriteByte(status)
+		if err := p.writeClient(ready.Message(protocol.ReadyForQueryMessageType).Encode, true); err != nil {
+			return err
+		}
+		p.markReady(status)
+		p.groups = p.groups[1:]
+	}
+	return nil
+}
+
+// failGroup records that the client was sent an error for the sync group
+// the backend is answering, by mammoth rather than the backend if own is
+// set.
+func (p *ProxyConnection) failGroup(own bool) {
+	p.groupsMtx.Lock()
+	if len(p.groups) > 0 {
+		p.groups[0].failed = true
+		p.groups[0].errored = p.groups[0].errored || own
+	}
+	p.groupsMtx.Unlock()
+}
+
+// rewriteServerMessage sends the rejection of a batch that was partly
+// forwarded ahead of the backend's ReadyForQuery, unless the backend
+// already failed the batch before reaching the rejected message. The
+// transaction status of the ReadyForQuery is rewritten, see readyStatus.
+func (p *ProxyConnection) rewriteServerMessage(m *protocol.Message) error {
+	switch m.Type {
+	case protocol.ErrorMessageType:
+		p.failGroup(false)
+
+	case protocol.ReadyForQueryMessageType:
+		p.groupsMtx.Lock()
+		var rejection *protocol.Error
+		errored := false
+		if len(p.groups) > 0 {
+			g := p.groups[0]
+			if !g.failed {
+				rejection = g.rejection
+			}
+			g.rejection = nil
+			g.errored = g.errored || rejection != nil
+			errored = g.errored
+		}
+		p.groupsMtx.Unlock()
+
+		if len(m.Body) == 1 {
+			m.Body[0] = p.readyStatus(m.Body[0], errored)
+		}
+		if rejection != nil {
+			return p.writeClient(func(w io.Writer) error {
+				return protocol.WriteError(w, *rejection)
+			}, false)
+		}
+	}
+	return nil
+}
+
+// retireGroup drops the sync group the backend has just answered with
+// ReadyForQuery, and answers the groups mammoth holds behind it.
+func (p *ProxyConnection) retireGroup() error {
+	p.groupsMtx.Lock()
+	if len(p.groups) > 0 {
+		p.groups = p.groups[1:]
+	}
+	p.groupsMtx.Unlock()
+	return p.answerGroups()
+}
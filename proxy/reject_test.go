@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/brunopadz/mammoth/config/file"
+)
+
+func guardConfig() *file.Config {
+	guard := true
+	return &file.Config{Policy: file.PolicyConfig{GuardDestructive: &guard}}
+}
+
+// A statement rejected right after password authentication used to be
+// queued behind the authentication messages, which no ReadyForQuery ever
+// answers.
+func TestRejectFirstStatementAfterPasswordAuth(t *testing.T) {
+	backend := newTestBackend(t, "secret")
+	conn := testConnect(t, startTestProxy(t, guardConfig()), backend)
+
+	if err := testExec(t, conn, "DROP TABLE t"); sqlState(err) != "42501" {
+		t.Fatalf("DROP TABLE: got %v, want SQLSTATE 42501", err)
+	}
+	if err := testExec(t, conn, "SELECT 1"); err != nil {
+		t.Fatalf("SELECT after the rejection: %v", err)
+	}
+	for _, q := range backend.received() {
+		if q == "DROP TABLE t" {
+			t.Error("the rejected statement reached the backend")
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+// limitRows enforces the policy's row limit on a message from the backend.
+// Rows are counted per statement, whether they come as DataRows or as the
+// CopyData of a COPY TO STDOUT; once the limit is passed the backend is
+// asked to cancel the statement, further rows are dropped, and the end of
+// the statement is reported to the client as an error. It returns false if
+// m must not be forwarded.
+func (p *ProxyConnection) limitRows(m *protocol.Message, flush bool) (bool, error) {
+	if p.policy.MaxRows <= 0 {
+		return true, nil
+	}
+
+	switch m.Type {
+	case protocol.CopyOutResponseMessageType:
+		p.copyOut = true
+
+	case protocol.CopyDataMessageType, protocol.DataRowMessageType:
+		if m.Type == protocol.CopyDataMessageType && !p.copyOut {
+			return true, nil
+		}
+		p.rowCount++
+		if p.rowCount <= p.policy.MaxRows {
+			return true, nil
+		}
+		if !p.rowLimitHit {
+			p.rowLimitHit = true
+			p.log.WithFields(logrus.Fields{
+				"rowLimit": p.policy.MaxRows,
+			}).Info("Row limit exceeded, cancelling statement")
+			p.audit(&audit.Event{
+				Type: audit.EventLimit,
+				Limit: &audit.Limit{
+					Kind:   "rows",
+					Limit:  int64(p.policy.MaxRows),
+					Action: "cancel",
+				},
+			})
+			go p.cancelStatement(p.statementsDone.Load())
+		}
+		return false, nil
+
+	case protocol.CopyDoneMessageType:
+		p.copyOut = false
+		return !p.rowLimitHit, nil
+
+	case protocol.CommandCompleteMessageType,
+		protocol.ErrorMessageType,
+		protocol.EmptyQueryMessageType,
+		protocol.PortalSuspendedMessageType:
+		p.copyOut = false
+		p.completeExecute()
+		p.statementsDone.Add(1)
+
+		// A suspended portal keeps counting across Executes, so the limit
+		// can't be dodged by fetching in batches
+		hit := p.rowLimitHit
+		if m.Type != protocol.PortalSuspendedMessageType || hit {
+			p.rowCount = 0
+			p.rowLimitHit = false
+		}
+		if !hit {
+			return true, nil
+		}
+
+		// Whether the statement completed or was cancelled, the client
+		// only gets to see our error
+		p.failGroup(true)
+		return false, p.writeClient(func(w io.Writer) error {
+			return protocol.WriteError(w, protocol.Error{
+				Severity: protocol.ErrorSeverityError,
+				Code:     protocol.ErrorCodeProgramLimitExceeded,
+				Message:  fmt.Sprintf("statement returned more than %d rows, the limit set by mammoth", p.policy.MaxRows),
+				Hint:     "Add a LIMIT clause or narrow down the query.",
+			})
+		}, flush)
+	}
+	return true, nil
+}
+
+// completeExecute records that the backend is done with an Execute of the
+// sync group it is answering.
+func (p *ProxyConnection) completeExecute() {
+	p.groupsMtx.Lock()
+	if len(p.groups) > 0 && p.groups[0].executes > 0 {
+		p.groups[0].executes--
+	}
+	p.groupsMtx.Unlock()
+}
+
+// cancelStatement cancels the statement over the row limit, done being the
+// number of statements the backend had completed when it passed the limit.
+// A cancel request hits whatever the backend runs when it gets the signal,
+// so nothing is forwarded to the backend until the request is through, and
+// none is sent if the statement has completed meanwhile or if the backend
+// was already sent another statement to run after it. In those cases the
+// statement runs to completion, its rows still being dropped.
+func (p *ProxyConnection) cancelStatement(done int64) {
+	p.serverMtx.Lock()
+	defer p.serverMtx.Unlock()
+
+	if p.statementsDone.Load() != done || !p.runsLastStatement() {
+		p.log.Debug("Not cancelling statement over row limit, the backend may have moved on")
+		return
+	}
+	if err := p.sendCancel(p.host, p.serverConn.RemoteAddr().String(), p.backendPID, p.backendSecret); err != nil {
+		p.log.Infof("Unable to cancel statement over row limit: %v", err)
+	}
+}
+
+// runsLastStatement reports whether the statement the backend is running is
+// the last one it was sent.
+func (p *ProxyConnection) runsLastStatement() bool {
+	p.groupsMtx.Lock()
+	defer p.groupsMtx.Unlock()
+
+	if len(p.groups) == 0 || p.groups[0].executes > 1 {
+		return false
+	}
+	for _, g := range p.groups[1:] {
+		if g.forwarded {
+			return false
+		}
+	}
+	return true
+}
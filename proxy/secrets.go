@@ -10,7 +10,9 @@ type BackendSecrets struct {
 type secret struct {
 	origSecret int32
 	host       string
-	port       string
+	// Address of the backend the session connected to, which passed the
+	// backend policy
+	addr string
 }
 
 func NewBackendSecrets() *BackendSecrets {
@@ -20,7 +22,7 @@ func NewBackendSecrets() *BackendSecrets {
 	}
 }
 
-func (s *BackendSecrets) Add(pid, origSecret int32, host, port string) int32 {
+func (s *BackendSecrets) Add(pid, origSecret int32, host, addr string) int32 {
 	s.mtx.Lock()
 	secrets, ok := s.m[pid]
 	if !ok {
@@ -35,7 +37,7 @@ func (s *BackendSecrets) Add(pid, origSecret int32, host, port string) int32 {
 		if !exists {
 			secrets[newSecret] = secret{
 				host:       host,
-				port:       port,
+				addr:       addr,
 				origSecret: origSecret,
 			}
 			break
@@ -48,6 +50,7 @@ func (s *BackendSecrets) Add(pid, origSecret int32, host, port string) int32 {
 
 func (s *BackendSecrets) Get(pid, newSecret int32) (secret, bool) {
 	s.mtx.Lock()
+	defer s.mtx.Unlock()
 	secrets, ok := s.m[pid]
 	if !ok {
 		return secret{}, false
@@ -56,12 +59,12 @@ func (s *BackendSecrets) Get(pid, newSecret int32) (secret, bool) {
 	if !ok {
 		return origSecret, false
 	}
-	s.mtx.Unlock()
 	return origSecret, true
 }
 
 func (s *BackendSecrets) Remove(pid, newSecret int32) bool {
 	s.mtx.Lock()
+	defer s.mtx.Unlock()
 	secrets, ok := s.m[pid]
 	if !ok {
 		return false
@@ -74,6 +77,5 @@ func (s *BackendSecrets) Remove(pid, newSecret int32) bool {
 	if len(secrets) == 0 {
 		delete(s.m, pid)
 	}
-	s.mtx.Unlock()
 	return true
 }
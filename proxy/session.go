@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"io"
+	"time"
+
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+// Upper bound on how often the watchdog checks the session's timeouts.
+const maxWatchdogInterval = time.Second
+
+// How long the last writes of a session may take before they are given up
+// on, e.g. because the client stopped reading.
+const closeWriteTimeout = 5 * time.Second
+
+// writeClient serialises writes to the client connection. fn receives a
+// buffered writer, which is flushed afterwards if flush is set.
+func (p *ProxyConnection) writeClient(fn func(w io.Writer) error, flush bool) error {
+	p.clientMtx.Lock()
+	defer p.clientMtx.Unlock()
+
+	if err := fn(p.clientW); err != nil {
+		return err
+	}
+	if flush {
+		return p.clientW.Flush()
+	}
+	return nil
+}
+
+// writeServer forwards a complete message to the backend.
+func (p *ProxyConnection) writeServer(m *protocol.Message) error {
+	p.serverMtx.Lock()
+	defer p.serverMtx.Unlock()
+
+	return m.Encode(p.serverConn)
+}
+
+// markReady records that the backend reported ReadyForQuery with the given
+// transaction status, i.e. it is now waiting on the client.
+func (p *ProxyConnection) markReady(status byte) {
+	p.txStatus.Store(int32(status))
+	p.lastReady.Store(time.Now().UnixNano())
+}
+
+// setCloseReason records why the session ended, unless a reason was
+// already given.
+func (p *ProxyConnection) setCloseReason(reason string) {
+	p.closeMtx.Lock()
+	defer p.closeMtx.Unlock()
+
+	if p.closeReason == "" {
+		p.closeReason = reason
+	}
+}
+
+// getCloseReason returns why the session ended, if known yet.
+func (p *ProxyConnection) getCloseReason() string {
+	p.closeMtx.Lock()
+	defer p.closeMtx.Unlock()
+
+	return p.closeReason
+}
+
+// close tears down both connections, unblocking both pumps. Only the first
+// reason given, to close or setCloseReason, is kept. What is still
+// buffered for the client is flushed if the client reads it in time; a
+// write blocked on a client that stopped reading fails at the deadline, so
+// the write mutex is released.
+func (p *ProxyConnection) close(reason string) {
+	p.closeOnce.Do(func() {
+		p.setCloseReason(reason)
+		p.closed.Store(true)
+
+		p.serverConn.Close()
+
+		p.clientConn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+		p.clientMtx.Lock()
+		p.clientW.Flush()
+		p.clientMtx.Unlock()
+		p.clientConn.Close()
+	})
+}
+
+// terminate ends the session on mammoth's initiative: the client is sent a
+// FATAL error, the backend a Terminate message, and both connections are
+// closed.
+func (p *ProxyConnection) terminate(reason, message string) {
+	if p.closed.Load() || !p.terminating.CompareAndSwap(false, true) {
+		return
+	}
+	p.log.Infof("Terminating session: %s", reason)
+
+	// Neither peer may be reading, e.g. in the middle of a large result
+	deadline := time.Now().Add(closeWriteTimeout)
+	p.clientConn.SetWriteDeadline(deadline)
+	p.serverConn.SetWriteDeadline(deadline)
+
+	p.writeClient(func(w io.Writer) error {
+		return protocol.WriteError(w, protocol.Error{
+			Severity: protocol.ErrorSeverityFatal,
+			Code:     protocol.ErrorCodeAdminShutdown,
+			Message:  message,
+		})
+	}, true)
+
+	terminate := &protocol.Message{Type: protocol.TerminateMessageType}
+	p.writeServer(terminate)
+
+	p.close(reason)
+}
+
+// watchdog enforces the session timeouts of the policy until done is
+// closed.
+func (p *ProxyConnection) watchdog(done <-chan struct{}) {
+	interval := maxWatchdogInterval
+	enabled := false
+	for _, d := range []time.Duration{
+		p.policy.IdleTimeout,
+		p.policy.IdleInTransactionTimeout,
+		p.policy.MaxSessionDuration,
+	} {
+		if d > 0 {
+			enabled = true
+			if d/4 < interval {
+				interval = d / 4
+			}
+		}
+	}
+	if !enabled || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if reason, message := p.checkTimeouts(now); reason != "" {
+				p.terminate(reason, message)
+				return
+			}
+		}
+	}
+}
+
+// checkTimeouts returns the reason and client-facing message for the first
+// expired timeout, or empty strings if none has expired.
+func (p *ProxyConnection) checkTimeouts(now time.Time) (string, string) {
+	if d := p.policy.MaxSessionDuration; d > 0 && now.Sub(p.started) >= d {
+		return "maximum session duration reached",
+			"terminating connection because the maximum session duration was reached"
+	}
+
+	// The session is idle when the backend has reported ReadyForQuery and
+	// the client has not sent anything since.
+	lastReady := p.lastReady.Load()
+	if lastReady == 0 || p.lastClientMsg.Load() > lastReady {
+		return "", ""
+	}
+	idle := now.Sub(time.Unix(0, lastReady))
+
+	if d := p.policy.IdleInTransactionTimeout; d > 0 && idle >= d &&
+		byte(p.txStatus.Load()) != protocol.TxStatusIdle {
+		return "idle-in-transaction timeout",
+			"terminating connection due to idle-in-transaction timeout"
+	}
+	if d := p.policy.IdleTimeout; d > 0 && idle >= d {
+		return "idle timeout", "terminating connection due to idle-session timeout"
+	}
+	return "", ""
+}
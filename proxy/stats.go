@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/protocol"
+	"github.com/brunopadz/mammoth/query"
+	"github.com/brunopadz/mammoth/stats"
+)
+
+// A call is the execution of a statement, from the moment it is forwarded
+// until the backend reports its completion. Calls are queued on their sync
+// group, and the backend completes them in order.
+type call struct {
+	fingerprint string
+	query       string
+	// A simple Query completes at ReadyForQuery, since it may hold several
+	// statements
+	simple bool
+	start  time.Time
+	rows   int64
+	failed bool
+}
+
+// trackCall queues a call on g for each statement the message executes,
+// g being nil if the message was rejected. It must be called before the
+// message is forwarded.
+func (p *ProxyConnection) trackCall(g *syncGroup, stmt *audit.Statement) {
+	if p.stats == nil || g == nil {
+		return
+	}
+
+	var c *call
+	switch stmt.Message {
+	case "Execute":
+		if q := p.portals[stmt.Portal]; q != nil {
+			if q.fingerprint == "" {
+				q.fingerprint = query.Fingerprint(q.query)
+			}
+			c = &call{fingerprint: q.fingerprint, query: q.query}
+		}
+	case "SimpleQuery":
+		if strings.TrimSpace(stmt.Query) != "" {
+			c = &call{fingerprint: query.Fingerprint(stmt.Query), query: stmt.Query, simple: true}
+		}
+	}
+	if c == nil {
+		return
+	}
+
+	c.start = time.Now()
+	p.groupsMtx.Lock()
+	g.calls = append(g.calls, c)
+	p.groupsMtx.Unlock()
+}
+
+// completeCalls matches a message from the backend against the calls of
+// the current sync group, and records the calls it completes. Calls the
+// backend skipped after an error are dropped at ReadyForQuery.
+func (p *ProxyConnection) completeCalls(m *protocol.Message) {
+	if p.stats == nil {
+		return
+	}
+
+	rows := p.callRows
+	switch m.Type {
+	case protocol.DataRowMessageType:
+		p.callRows++
+		return
+	case protocol.CommandCompleteMessageType:
+		if n, ok := commandRows(m); ok {
+			rows = n
+		}
+	case protocol.EmptyQueryMessageType,
+		protocol.PortalSuspendedMessageType,
+		protocol.ErrorMessageType,
+		protocol.ReadyForQueryMessageType:
+	default:
+		return
+	}
+	p.callRows = 0
+
+	var done *call
+	p.groupsMtx.Lock()
+	if len(p.groups) > 0 && len(p.groups[0].calls) > 0 {
+		g := p.groups[0]
+		c := g.calls[0]
+		c.rows += rows
+		switch {
+		case m.Type == protocol.ReadyForQueryMessageType:
+			if c.simple {
+				done = c
+			}
+			g.calls = nil
+		default:
+			c.failed = c.failed || m.Type == protocol.ErrorMessageType
+			if !c.simple {
+				done = c
+				g.calls = g.calls[1:]
+			}
+		}
+	}
+	p.groupsMtx.Unlock()
+
+	if done != nil {
+		p.stats.Record(&stats.Execution{
+			Fingerprint: done.fingerprint,
+			Query:       done.query,
+			User:        p.user,
+			Target:      p.target,
+			Duration:    time.Since(done.start),
+			Rows:        done.rows,
+			Failed:      done.failed,
+		})
+	}
+}
+
+// commandRows returns the number of rows a CommandComplete tag reports,
+// e.g. 3 for "INSERT 0 3". Tags such as "CREATE TABLE" report none.
+func commandRows(m *protocol.Message) (int64, bool) {
+	tag, err := m.Reader().ReadString()
+	if err != nil {
+		return 0, false
+	}
+	i := strings.LastIndexByte(tag, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(tag[i+1:], 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,107 @@
+package query
+
+// Statement classes, after pgaudit's. COPY has a class of its own rather
+// than counting as READ or WRITE.
+const (
+	ClassRead     = "READ"
+	ClassWrite    = "WRITE"
+	ClassFunction = "FUNCTION"
+	ClassRole     = "ROLE"
+	ClassDDL      = "DDL"
+	ClassCopy     = "COPY"
+	ClassMisc     = "MISC"
+)
+
+// Classes lists every statement class.
+var Classes = []string{ClassRead, ClassWrite, ClassFunction, ClassRole, ClassDDL, ClassCopy, ClassMisc}
+
+// Class returns the class of the statement:
+//
+//	READ      SELECT, VALUES, TABLE and cursor declarations
+//	WRITE     INSERT, UPDATE, DELETE, MERGE and TRUNCATE, and reads with a
+//	          data-modifying WITH clause
+//	FUNCTION  CALL and DO
+//	ROLE      GRANT, REVOKE, REASSIGN OWNED, ALTER DEFAULT PRIVILEGES, and
+//	          creating, altering or dropping roles, users and groups
+//	DDL       every other CREATE, ALTER and DROP, SELECT INTO, COMMENT,
+//	          SECURITY LABEL and IMPORT FOREIGN SCHEMA
+//	COPY      COPY in either direction
+//	MISC      everything else, e.g. SET, SHOW, VACUUM, BEGIN or a plain
+//	          EXPLAIN
+//
+// EXPLAIN ANALYZE has the class of the statement it runs, and PREPARE
+// that of the statement it prepares.
+func (s Statement) Class() string {
+	s, ok := s.executed()
+	if !ok {
+		return ClassMisc
+	}
+
+	switch s.Command() {
+	case "SELECT", "VALUES", "TABLE", "WITH":
+		if s.HasTopLevel("INTO") {
+			// SELECT INTO creates a table
+			return ClassDDL
+		}
+		for _, sub := range s.subStatements() {
+			if sub.Class() == ClassWrite {
+				return ClassWrite
+			}
+		}
+		return ClassRead
+	case "DECLARE":
+		return ClassRead
+	case "INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE":
+		return ClassWrite
+	case "CALL", "DO":
+		return ClassFunction
+	case "GRANT", "REVOKE", "REASSIGN":
+		return ClassRole
+	case "CREATE", "ALTER", "DROP":
+		if s.isRoleDDL() {
+			return ClassRole
+		}
+		return ClassDDL
+	case "COMMENT", "SECURITY", "IMPORT":
+		return ClassDDL
+	case "COPY":
+		return ClassCopy
+	}
+	return ClassMisc
+}
+
+// isRoleDDL reports whether a CREATE, ALTER or DROP statement is about
+// roles or privileges rather than database objects.
+func (s Statement) isRoleDDL() bool {
+	t := s.Tokens
+	if len(t) < 2 {
+		return false
+	}
+	switch {
+	case t[1].Is("ROLE"), t[1].Is("GROUP"):
+		return true
+	case t[1].Is("USER"):
+		// USER MAPPING belongs to a foreign server
+		return len(t) < 3 || !t[2].Is("MAPPING")
+	case t[1].Is("DEFAULT"):
+		return len(t) > 2 && t[2].Is("PRIVILEGES")
+	case t[1].Is("OWNED"):
+		// DROP OWNED
+		return true
+	}
+	return false
+}
+
+// QueryClasses returns the classes of the statements of a query string,
+// without duplicates, in the order they first appear.
+func QueryClasses(q string) []string {
+	var classes []string
+	seen := map[string]bool{}
+	for _, s := range Split(q) {
+		if c := s.Class(); !seen[c] {
+			seen[c] = true
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
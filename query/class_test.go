@@ -0,0 +1,30 @@
+package query
+
+import "testing"
+
+func TestClass(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", ClassRead},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", ClassWrite},
+		{"SELECT * INTO u FROM t", ClassDDL},
+		{"DELETE FROM t WHERE id = 1", ClassWrite},
+		{"DO $$ BEGIN NULL; END $$", ClassFunction},
+		{"CREATE ROLE r", ClassRole},
+		{"CREATE USER MAPPING FOR alice SERVER s", ClassDDL},
+		{"COPY t TO STDOUT", ClassCopy},
+		{"SET search_path = s", ClassMisc},
+		{"EXPLAIN DELETE FROM t", ClassMisc},
+		{"EXPLAIN ANALYZE DELETE FROM t", ClassWrite},
+		{"PREPARE p AS SELECT 1", ClassRead},
+		{"PREPARE p (int) AS DELETE FROM t WHERE id = $1", ClassWrite},
+		{"EXECUTE p", ClassMisc},
+	}
+	for _, tt := range tests {
+		if got := Split(tt.query)[0].Class(); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package query
+
+// Destructive describes why a statement is considered destructive: an
+// unqualified DELETE or UPDATE, a TRUNCATE or a DROP. It returns "" for
+// any other statement. Data-modifying statements nested in WITH clauses,
+// statements run by EXPLAIN ANALYZE, prepared by PREPARE or found in the
+// code block of a DO statement are checked too.
+func (s Statement) Destructive() string {
+	s, ok := s.executed()
+	if !ok {
+		return ""
+	}
+
+	if s.Command() == "DO" {
+		for _, body := range s.DoBodies() {
+			for _, sub := range body.blockStatements() {
+				if reason := sub.Destructive(); reason != "" {
+					return reason
+				}
+			}
+		}
+		return ""
+	}
+
+	switch s.Command() {
+	case "DELETE":
+		if !s.HasTopLevel("WHERE") {
+			return "DELETE without a WHERE clause"
+		}
+	case "UPDATE":
+		if !s.HasTopLevel("WHERE") {
+			return "UPDATE without a WHERE clause"
+		}
+	case "TRUNCATE":
+		return "TRUNCATE"
+	case "DROP":
+		if len(s.Tokens) > 1 {
+			return "DROP " + s.Tokens[1].Upper()
+		}
+		return "DROP"
+	}
+
+	for _, sub := range s.subStatements() {
+		if reason := sub.Destructive(); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// executed returns the statement that actually runs: for EXPLAIN ANALYZE
+// that is the explained statement, while a plain EXPLAIN runs nothing. For
+// PREPARE it is the prepared statement, as it runs whenever the client
+// sends an EXECUTE that can't be told apart from any other.
+func (s Statement) executed() (Statement, bool) {
+	if len(s.Tokens) > 0 && s.Tokens[0].Is("PREPARE") {
+		// PREPARE name [ ( data_type [, ...] ) ] AS statement
+		depth := 0
+		for i, t := range s.Tokens {
+			switch {
+			case t.IsPunct('('):
+				depth++
+			case t.IsPunct(')'):
+				depth--
+			case t.Is("AS") && depth == 0 && i+1 < len(s.Tokens):
+				return Statement{Text: s.Text, Tokens: s.Tokens[i+1:]}, true
+			}
+		}
+		return Statement{}, false
+	}
+	if len(s.Tokens) == 0 || !s.Tokens[0].Is("EXPLAIN") {
+		return s, true
+	}
+
+	analyze := false
+	i := 1
+	for i < len(s.Tokens) {
+		t := s.Tokens[i]
+		switch {
+		case t.IsPunct('('):
+			// EXPLAIN (ANALYZE [true], ...) statement
+			for i++; i < len(s.Tokens) && !s.Tokens[i].IsPunct(')'); i++ {
+				if s.Tokens[i].Is("ANALYZE") || s.Tokens[i].Is("ANALYSE") {
+					analyze = !(i+1 < len(s.Tokens) &&
+						(s.Tokens[i+1].Is("false") || s.Tokens[i+1].Is("off") ||
+							s.Tokens[i+1].Text == "0"))
+				}
+			}
+			i++
+			continue
+		case t.Is("ANALYZE") || t.Is("ANALYSE"):
+			analyze = true
+			i++
+			continue
+		case t.Is("VERBOSE"):
+			i++
+			continue
+		}
+		break
+	}
+
+	if !analyze || i >= len(s.Tokens) {
+		return Statement{}, false
+	}
+	return Statement{Text: s.Text, Tokens: s.Tokens[i:]}, true
+}
+
+// subStatements returns the parenthesised groups of the statement that
+// are statements of their own, such as the bodies of WITH clauses.
+func (s Statement) subStatements() []Statement {
+	var subs []Statement
+	depth := 0
+	start := -1
+	for i, t := range s.Tokens {
+		switch {
+		case t.IsPunct('('):
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case t.IsPunct(')'):
+			depth--
+			if depth == 0 && start >= 0 && start < i {
+				switch s.Tokens[start].Upper() {
+				case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "WITH", "VALUES", "TABLE":
+					subs = append(subs, Statement{Tokens: s.Tokens[start:i]})
+				}
+			}
+		}
+	}
+	return subs
+}
+
+// blockStatements returns the statements that start within a statement of
+// a PL/pgSQL code block: the block splits on semicolons like plain SQL,
+// but a statement may follow BEGIN, THEN, ELSE or LOOP, as in
+// "IF found THEN DELETE FROM t".
+func (s Statement) blockStatements() []Statement {
+	subs := []Statement{s}
+	for i, t := range s.Tokens {
+		if i+1 == len(s.Tokens) {
+			break
+		}
+		if t.Is("BEGIN") || t.Is("THEN") || t.Is("ELSE") || t.Is("LOOP") {
+			subs = append(subs, Statement{Tokens: s.Tokens[i+1:]})
+		}
+	}
+	return subs
+}
@@ -0,0 +1,51 @@
+package query
+
+import "testing"
+
+func TestDestructive(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM t", ""},
+		{"DELETE FROM t", "DELETE without a WHERE clause"},
+		{"DELETE FROM t WHERE id = 1", ""},
+		{"UPDATE t SET a = 1", "UPDATE without a WHERE clause"},
+		{"UPDATE t SET a = (SELECT 1 WHERE true)", "UPDATE without a WHERE clause"},
+		{"UPDATE t SET a = 1 WHERE id = 1", ""},
+		{"TRUNCATE t", "TRUNCATE"},
+		{"DROP TABLE t", "DROP TABLE"},
+		{"drop schema s cascade", "DROP SCHEMA"},
+		{"CREATE TABLE t (id int REFERENCES u ON DELETE CASCADE)", ""},
+		{"SELECT * FROM t FOR UPDATE", ""},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", "DELETE without a WHERE clause"},
+		{"WITH d AS (DELETE FROM t WHERE id = 1 RETURNING *) SELECT * FROM d", ""},
+
+		{"EXPLAIN DELETE FROM t", ""},
+		{"EXPLAIN ANALYZE DELETE FROM t", "DELETE without a WHERE clause"},
+		{"EXPLAIN (ANALYZE, VERBOSE) TRUNCATE t", "TRUNCATE"},
+		{"EXPLAIN (ANALYZE false) DELETE FROM t", ""},
+
+		{"PREPARE p AS DELETE FROM t", "DELETE without a WHERE clause"},
+		{"PREPARE p (int, text) AS UPDATE t SET a = $2", "UPDATE without a WHERE clause"},
+		{"PREPARE p (int) AS DELETE FROM t WHERE id = $1", ""},
+		{"PREPARE p AS SELECT 1", ""},
+		{"PREPARE p", ""},
+
+		{"DO $$ BEGIN DELETE FROM t; END $$", "DELETE without a WHERE clause"},
+		{"DO $$BEGIN PERFORM 1; DROP TABLE t; END$$", "DROP TABLE"},
+		{"DO $$ DECLARE n int; BEGIN IF n > 0 THEN TRUNCATE t; END IF; END $$", "TRUNCATE"},
+		{"DO $$ BEGIN FOR i IN 1..3 LOOP UPDATE t SET a = i; END LOOP; END $$", "UPDATE without a WHERE clause"},
+		{"DO 'BEGIN DELETE FROM t WHERE id = 1; END'", ""},
+		{"DO $$ BEGIN PERFORM * FROM t FOR UPDATE; END $$", ""},
+	}
+	for _, tt := range tests {
+		stmts := Split(tt.query)
+		if len(stmts) != 1 {
+			t.Fatalf("%q: %d statements", tt.query, len(stmts))
+		}
+		if got := stmts[0].Destructive(); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
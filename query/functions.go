@@ -0,0 +1,108 @@
+package query
+
+import (
+	"strings"
+)
+
+// Name returns the identifier a token denotes: bare words are folded to
+// lower case, quoted identifiers are unquoted as-is.
+func (t Token) Name() string {
+	switch t.Kind {
+	case Ident:
+		return strings.ToLower(t.Text)
+	case QuotedIdent:
+		text := t.Text
+		if strings.HasPrefix(text, "U&") || strings.HasPrefix(text, "u&") {
+			text = text[2:]
+		}
+		if len(text) >= 2 {
+			text = text[1 : len(text)-1]
+		}
+		return strings.ReplaceAll(text, `""`, `"`)
+	}
+	return ""
+}
+
+// FunctionCalls returns the names of the functions the statement calls,
+// without schema qualification. Any name directly followed by an opening
+// parenthesis counts, so the result may include a few keywords and table
+// names as well.
+func (s Statement) FunctionCalls() []string {
+	var names []string
+	for i := 0; i+1 < len(s.Tokens); i++ {
+		t := s.Tokens[i]
+		if (t.Kind == Ident || t.Kind == QuotedIdent) && s.Tokens[i+1].IsPunct('(') {
+			names = append(names, t.Name())
+		}
+	}
+	return names
+}
+
+// Where COPY statements read from or write to.
+const (
+	CopyStdio   = "STDIO"
+	CopyProgram = "PROGRAM"
+	CopyFile    = "FILE"
+)
+
+// CopyEndpoint returns where a COPY statement reads from or writes to on
+// the server: CopyProgram, CopyFile or CopyStdio. It returns "" if the
+// statement is not a COPY.
+func (s Statement) CopyEndpoint() string {
+	if s.Command() != "COPY" {
+		return ""
+	}
+	top := s.TopLevel()
+	for i, t := range top {
+		if !(t.Is("TO") || t.Is("FROM")) || i+1 >= len(top) {
+			continue
+		}
+		next := top[i+1]
+		switch {
+		case next.Is("PROGRAM"):
+			return CopyProgram
+		case next.Is("STDIN") || next.Is("STDOUT"):
+			return CopyStdio
+		case next.Kind == String:
+			return CopyFile
+		}
+	}
+	return CopyStdio
+}
+
+// DoBodies returns the statements of the code block of a DO statement, so
+// that they can be checked like any other. Code that builds queries
+// dynamically is of course out of reach.
+func (s Statement) DoBodies() []Statement {
+	if s.Command() != "DO" {
+		return nil
+	}
+	var stmts []Statement
+	for _, t := range s.Tokens {
+		if t.Kind == String {
+			stmts = append(stmts, Split(StringValue(t))...)
+		}
+	}
+	return stmts
+}
+
+// StringValue returns the contents of a string literal token, without
+// its quotes. Escape sequences are left alone, except for doubled quotes.
+func StringValue(t Token) string {
+	text := t.Text
+	if t.Kind != String {
+		return text
+	}
+	if strings.HasPrefix(text, "$") {
+		end := strings.IndexByte(text[1:], '$') + 2
+		if end < 2 || len(text) < 2*end {
+			return text
+		}
+		return text[end : len(text)-end]
+	}
+	start := strings.IndexByte(text, '\'')
+	if start < 0 || len(text) < start+2 {
+		return text
+	}
+	return strings.ReplaceAll(text[start+1:len(text)-1], "''", "'")
+}
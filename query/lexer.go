@@ -0,0 +1,271 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenKind identifies the lexical class of a Token.
+type TokenKind int
+
+const (
+	// Ident is a bare word: a keyword or an unquoted identifier.
+	Ident TokenKind = iota
+	// QuotedIdent is a double-quoted identifier, quotes included.
+	QuotedIdent
+	// String is a string literal of any flavour, quotes included.
+	String
+	// Number is a numeric literal.
+	Number
+	// Param is a positional parameter such as $1.
+	Param
+	// Operator is a run of operator characters.
+	Operator
+	// Punct is a single punctuation character: ( ) [ ] , ; . :
+	Punct
+	// Comment is a line or block comment, delimiters included.
+	Comment
+)
+
+// Token is a lexical token of a SQL string. Pos is the byte offset of the
+// token in the lexed string.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos  int
+}
+
+// Is reports whether the token is the bare word kw, ignoring case.
+func (t Token) Is(kw string) bool {
+	return t.Kind == Ident && strings.EqualFold(t.Text, kw)
+}
+
+// IsPunct reports whether the token is the punctuation character c.
+func (t Token) IsPunct(c byte) bool {
+	return t.Kind == Punct && len(t.Text) == 1 && t.Text[0] == c
+}
+
+// Upper returns the token text upper-cased, for comparing keywords.
+func (t Token) Upper() string {
+	return strings.ToUpper(t.Text)
+}
+
+const operatorChars = "+-*/<>=~!@#%^&|`?"
+
+// Lex splits a SQL string into tokens, following the PostgreSQL lexical
+// rules closely enough for auditing: strings, dollar quoting, quoted
+// identifiers and nested comments are recognised, so that their contents
+// are never mistaken for keywords. Malformed input (e.g. an unterminated
+// string) produces a final token running to the end of the input.
+func Lex(s string) []Token {
+	var tokens []Token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+			continue
+
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end
+			}
+			tokens = append(tokens, Token{Kind: Comment, Text: s[start:i], Pos: start})
+
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			i = skipBlockComment(s, i)
+			tokens = append(tokens, Token{Kind: Comment, Text: s[start:i], Pos: start})
+
+		case c == '\'':
+			i = skipQuoted(s, i, '\'', false)
+			tokens = append(tokens, Token{Kind: String, Text: s[start:i], Pos: start})
+
+		case (c == 'e' || c == 'E') && i+1 < len(s) && s[i+1] == '\'':
+			i = skipQuoted(s, i+1, '\'', true)
+			tokens = append(tokens, Token{Kind: String, Text: s[start:i], Pos: start})
+
+		case (c == 'b' || c == 'B' || c == 'x' || c == 'X' || c == 'n' || c == 'N') &&
+			i+1 < len(s) && s[i+1] == '\'':
+			i = skipQuoted(s, i+1, '\'', false)
+			tokens = append(tokens, Token{Kind: String, Text: s[start:i], Pos: start})
+
+		case (c == 'u' || c == 'U') && i+2 < len(s) && s[i+1] == '&' && (s[i+2] == '\'' || s[i+2] == '"'):
+			kind := String
+			if s[i+2] == '"' {
+				kind = QuotedIdent
+			}
+			i = skipQuoted(s, i+2, s[i+2], false)
+			tokens = append(tokens, Token{Kind: kind, Text: s[start:i], Pos: start})
+
+		case c == '"':
+			i = skipQuoted(s, i, '"', false)
+			tokens = append(tokens, Token{Kind: QuotedIdent, Text: s[start:i], Pos: start})
+
+		case c == '$':
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				i++
+				for i < len(s) && isDigit(s[i]) {
+					i++
+				}
+				tokens = append(tokens, Token{Kind: Param, Text: s[start:i], Pos: start})
+			} else if end, ok := skipDollarQuoted(s, i); ok {
+				i = end
+				tokens = append(tokens, Token{Kind: String, Text: s[start:i], Pos: start})
+			} else {
+				i++
+				tokens = append(tokens, Token{Kind: Operator, Text: s[start:i], Pos: start})
+			}
+
+		case isDigit(c) || (c == '.' && i+1 < len(s) && isDigit(s[i+1])):
+			i = skipNumber(s, i)
+			tokens = append(tokens, Token{Kind: Number, Text: s[start:i], Pos: start})
+
+		case isIdentStart(s, i):
+			for i < len(s) && isIdentCont(s, i) {
+				_, size := utf8.DecodeRuneInString(s[i:])
+				i += size
+			}
+			tokens = append(tokens, Token{Kind: Ident, Text: s[start:i], Pos: start})
+
+		case strings.IndexByte("()[],;.:", c) >= 0:
+			i++
+			// Keep casts (::) together so they aren't confused with slices
+			if c == ':' && i < len(s) && s[i] == ':' {
+				i++
+				tokens = append(tokens, Token{Kind: Operator, Text: s[start:i], Pos: start})
+				continue
+			}
+			tokens = append(tokens, Token{Kind: Punct, Text: s[start:i], Pos: start})
+
+		case strings.IndexByte(operatorChars, c) >= 0:
+			for i < len(s) && strings.IndexByte(operatorChars, s[i]) >= 0 {
+				// A comment start ends the operator
+				if i > start && i+1 < len(s) &&
+					((s[i] == '-' && s[i+1] == '-') || (s[i] == '/' && s[i+1] == '*')) {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, Token{Kind: Operator, Text: s[start:i], Pos: start})
+
+		default:
+			_, size := utf8.DecodeRuneInString(s[i:])
+			i += size
+			tokens = append(tokens, Token{Kind: Operator, Text: s[start:i], Pos: start})
+		}
+	}
+	return tokens
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(s string, i int) bool {
+	c := s[i]
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	if c < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsLetter(r)
+}
+
+func isIdentCont(s string, i int) bool {
+	return isIdentStart(s, i) || isDigit(s[i]) || s[i] == '$'
+}
+
+// skipQuoted returns the offset just past the quoted section starting at
+// s[i]. A doubled quote character is an escaped quote; with backslash
+// set, so is a backslash-escaped one.
+func skipQuoted(s string, i int, quote byte, backslash bool) int {
+	i++
+	for i < len(s) {
+		switch {
+		case backslash && s[i] == '\\':
+			i += 2
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i += 2
+			} else {
+				return i + 1
+			}
+		default:
+			i++
+		}
+	}
+	return len(s)
+}
+
+func skipBlockComment(s string, i int) int {
+	depth := 0
+	for i < len(s) {
+		if i+1 < len(s) && s[i] == '/' && s[i+1] == '*' {
+			depth++
+			i += 2
+		} else if i+1 < len(s) && s[i] == '*' && s[i+1] == '/' {
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		} else {
+			i++
+		}
+	}
+	return len(s)
+}
+
+// skipDollarQuoted recognises $tag$...$tag$ strings.
+func skipDollarQuoted(s string, i int) (int, bool) {
+	j := i + 1
+	for j < len(s) && s[j] != '$' {
+		if !isIdentCont(s, j) || (j == i+1 && isDigit(s[j])) {
+			return 0, false
+		}
+		j++
+	}
+	if j >= len(s) {
+		return 0, false
+	}
+	tag := s[i : j+1]
+	end := strings.Index(s[j+1:], tag)
+	if end < 0 {
+		return len(s), true
+	}
+	return j + 1 + end + len(tag), true
+}
+
+func skipNumber(s string, i int) int {
+	for i < len(s) && (isDigit(s[i]) || s[i] == '_') {
+		i++
+	}
+	if i < len(s) && s[i] == '.' && !(i+1 < len(s) && s[i+1] == '.') {
+		i++
+		for i < len(s) && (isDigit(s[i]) || s[i] == '_') {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			i = j
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Value is a literal or positional parameter in a statement. Column is
+// the column it is compared with or assigned to, if the statement makes
+// that plain; the value of a PASSWORD clause counts as column "password".
+type Value struct {
+	Token  Token
+	Column string
+}
+
+// IsValue reports whether the token is a literal or a parameter.
+func (t Token) IsValue() bool {
+	return t.Kind == String || t.Kind == Number || t.Kind == Param
+}
+
+// ParamNumber returns n for a parameter $n, or 0 for any other token.
+func (t Token) ParamNumber() int {
+	if t.Kind != Param {
+		return 0
+	}
+	n, _ := strconv.Atoi(t.Text[1:])
+	return n
+}
+
+func isComparison(t Token) bool {
+	if t.Kind == Operator {
+		switch t.Text {
+		case "=", "<>", "!=", "<", ">", "<=", ">=":
+			return true
+		}
+	}
+	return t.Is("LIKE") || t.Is("ILIKE")
+}
+
+func isName(t Token) bool {
+	return t.Kind == Ident || t.Kind == QuotedIdent
+}
+
+func isSign(t Token) bool {
+	return t.Kind == Operator && (t.Text == "-" || t.Text == "+")
+}
+
+// Values returns the literals and parameters of the statement, in order.
+// Columns are recognised in "column <op> value" comparisons and
+// assignments (either way round), in INSERT column and VALUES lists, and
+// after PASSWORD.
+func (s Statement) Values() []Value {
+	insertColumns := s.insertColumns()
+
+	var values []Value
+	tokens := s.Tokens
+	for i, t := range tokens {
+		if !t.IsValue() {
+			continue
+		}
+		v := Value{Token: t, Column: insertColumns[i]}
+
+		j := i - 1
+		if j >= 1 && isSign(tokens[j]) {
+			j--
+		}
+		switch {
+		case v.Column != "":
+		case j >= 0 && tokens[j].Is("PASSWORD"):
+			v.Column = "password"
+		case j >= 1 && isComparison(tokens[j]) && isName(tokens[j-1]):
+			v.Column = tokens[j-1].Name()
+		case i+2 < len(tokens) && isComparison(tokens[i+1]) && isName(tokens[i+2]):
+			v.Column = tokens[i+2].Name()
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// insertColumns maps the indexes of the value tokens of an
+// "INSERT INTO t (a, b) VALUES (...), (...)" statement to their columns.
+func (s Statement) insertColumns() map[int]string {
+	tokens := s.Tokens
+	if len(tokens) == 0 || !tokens[0].Is("INSERT") {
+		return nil
+	}
+
+	// Find the column list: the first parenthesis, if it comes before
+	// VALUES
+	i := 0
+	for i < len(tokens) && !tokens[i].IsPunct('(') {
+		if tokens[i].Is("VALUES") || tokens[i].Is("SELECT") {
+			return nil
+		}
+		i++
+	}
+	var columns []string
+	for i++; i < len(tokens) && !tokens[i].IsPunct(')'); i++ {
+		if isName(tokens[i]) {
+			columns = append(columns, tokens[i].Name())
+		}
+	}
+	if i+1 >= len(tokens) || !tokens[i+1].Is("VALUES") {
+		return nil
+	}
+
+	found := map[int]string{}
+	depth := 0
+	column := 0
+	for i += 2; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.IsPunct('(') || t.IsPunct('['):
+			if depth == 0 {
+				column = 0
+			}
+			depth++
+		case t.IsPunct(')') || t.IsPunct(']'):
+			depth--
+			if depth < 0 {
+				return found
+			}
+		case depth == 0 && !t.IsPunct(','):
+			// ON CONFLICT or RETURNING
+			return found
+		case depth == 1 && t.IsPunct(','):
+			column++
+		case t.IsValue() && column < len(columns):
+			found[i] = columns[column]
+		}
+	}
+	return found
+}
+
+// Rewrite returns s with the text of each of the given tokens, which must
+// come from lexing s and be in order, replaced by what repl returns.
+func Rewrite(s string, tokens []Token, repl func(Token) string) string {
+	b := strings.Builder{}
+	last := 0
+	for _, t := range tokens {
+		b.WriteString(s[last:t.Pos])
+		b.WriteString(repl(t))
+		last = t.Pos + len(t.Text)
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// Normalize replaces every string and numeric literal in s with a
+// positional parameter, numbered after the highest one s already uses,
+// so that queries differing only in their constants read the same.
+func Normalize(s string) string {
+	var literals []Token
+	n := 0
+	for _, t := range Lex(s) {
+		switch t.Kind {
+		case String, Number:
+			literals = append(literals, t)
+		case Param:
+			if p := t.ParamNumber(); p > n {
+				n = p
+			}
+		}
+	}
+	return Rewrite(s, literals, func(Token) string {
+		n++
+		return "$" + strconv.Itoa(n)
+	})
+}
+
+// Fingerprint identifies the shape of s: queries that differ only in their
+// constants, parameter numbers, comments, whitespace or the case of bare
+// words share a fingerprint. It is 16 hex digits long.
+func Fingerprint(s string) string {
+	h := sha256.New()
+	for _, t := range Lex(s) {
+		switch {
+		case t.Kind == Comment:
+			continue
+		case t.IsValue():
+			h.Write([]byte("?"))
+		case t.Kind == Ident:
+			h.Write([]byte(strings.ToLower(t.Text)))
+		default:
+			h.Write([]byte(t.Text))
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
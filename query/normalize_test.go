@@ -0,0 +1,86 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		query string
+		// Each value's text, followed by "=column" if it has one
+		want []string
+	}{
+		{"SELECT * FROM t WHERE ssn = '123-45-6789'", []string{"'123-45-6789'=ssn"}},
+		{"SELECT * FROM t WHERE '123-45-6789' = ssn", []string{"'123-45-6789'=ssn"}},
+		{"SELECT * FROM t WHERE t.ssn <> $1", []string{"$1=ssn"}},
+		{`SELECT * FROM t WHERE "SSN" = 'x'`, []string{"'x'=SSN"}},
+		{"SELECT * FROM t WHERE balance > -100", []string{"100=balance"}},
+		{"SELECT * FROM t WHERE email ILIKE '%@example.com'", []string{"'%@example.com'=email"}},
+		{"UPDATE t SET ssn = $1, name = 'bob' WHERE id = 7", []string{"$1=ssn", "'bob'=name", "7=id"}},
+		{"SELECT * FROM t WHERE lower(ssn) = 'x'", []string{"'x'"}},
+		{"SELECT * FROM t WHERE id IN (1, 2)", []string{"1", "2"}},
+		{"SELECT 'x', 42", []string{"'x'", "42"}},
+		{"INSERT INTO t (id, ssn) VALUES (1, '123'), ($1, $2)", []string{"1=id", "'123'=ssn", "$1=id", "$2=ssn"}},
+		{"INSERT INTO t (id, tags) VALUES (1, ARRAY['a', 'b'])", []string{"1=id", "'a'=tags", "'b'=tags"}},
+		{"INSERT INTO t (id, ssn) VALUES (1, '123') ON CONFLICT (id) DO UPDATE SET ssn = 'x'", []string{"1=id", "'123'=ssn", "'x'=ssn"}},
+		{"INSERT INTO t VALUES (1, '123')", []string{"1", "'123'"}},
+		{"INSERT INTO t (id, ssn) SELECT 1, '123'", []string{"1", "'123'"}},
+		{"ALTER ROLE alice PASSWORD 's3cret'", []string{"'s3cret'=password"}},
+		{"CREATE USER bob WITH ENCRYPTED PASSWORD 's3cret' VALID UNTIL '2030-01-01'", []string{"'s3cret'=password", "'2030-01-01'"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range Split(tt.query)[0].Values() {
+			s := v.Token.Text
+			if v.Column != "" {
+				s += "=" + v.Column
+			}
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "SELECT $1"},
+		{"SELECT * FROM t WHERE ssn = '123-45-6789' AND n > 1.5e3", "SELECT * FROM t WHERE ssn = $1 AND n > $2"},
+		{"SELECT * FROM t WHERE a = $2 AND b = 'x' AND c = $1", "SELECT * FROM t WHERE a = $2 AND b = $3 AND c = $1"},
+		{"SELECT E'it''s', $$dollar$$, $q$quoted$q$", "SELECT $1, $2, $3"},
+		{"SELECT 'x' -- 'not a literal'", "SELECT $1 -- 'not a literal'"},
+		{`SELECT "col" FROM t`, `SELECT "col" FROM t`},
+		{"ALTER ROLE alice PASSWORD 's3cret'", "ALTER ROLE alice PASSWORD $1"},
+		{"SELECT 1; SELECT 'a'", "SELECT $1; SELECT $2"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.query); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	same := []string{
+		"SELECT * FROM t WHERE id = 1",
+		"select * from T where ID = 'abc'",
+		"SELECT *\n  FROM t -- comment\n WHERE id = $3",
+	}
+	for _, q := range same[1:] {
+		if Fingerprint(q) != Fingerprint(same[0]) {
+			t.Errorf("%q and %q have different fingerprints", q, same[0])
+		}
+	}
+	if Fingerprint(`SELECT * FROM "T" WHERE id = 1`) == Fingerprint(same[0]) {
+		t.Error("quoted and bare identifiers share a fingerprint")
+	}
+	if f := Fingerprint(same[0]); len(f) != 16 || strings.Trim(f, "0123456789abcdef") != "" {
+		t.Errorf("fingerprint %q, want 16 hex digits", f)
+	}
+}
@@ -0,0 +1,152 @@
+package query
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Access modes of relations.
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// Relation is a table, view, sequence or index referenced by a statement.
+// Schema is empty unless the statement qualifies the name.
+type Relation struct {
+	Schema string
+	Name   string
+	Access string
+}
+
+// Parse tree fields holding the relations a statement writes to: their
+// data, definition or privileges. Relations anywhere else are read. COPY
+// and DROP are handled separately.
+var writeFields = map[string]bool{
+	"InsertStmt.relation":            true,
+	"UpdateStmt.relation":            true,
+	"DeleteStmt.relation":            true,
+	"MergeStmt.relation":             true,
+	"TruncateStmt.relations":         true,
+	"IntoClause.rel":                 true,
+	"CreateStmt.relation":            true,
+	"AlterTableStmt.relation":        true,
+	"IndexStmt.relation":             true,
+	"RenameStmt.relation":            true,
+	"ViewStmt.view":                  true,
+	"RefreshMatViewStmt.relation":    true,
+	"CreateTrigStmt.relation":        true,
+	"RuleStmt.relation":              true,
+	"CreatePolicyStmt.table":         true,
+	"AlterPolicyStmt.table":          true,
+	"GrantStmt.objects":              true,
+	"AlterObjectSchemaStmt.relation": true,
+	"AlterOwnerStmt.relation":        true,
+	"CreateSeqStmt.sequence":         true,
+	"AlterSeqStmt.sequence":          true,
+}
+
+// Object types of DROP statements that drop relations.
+var dropRelationTypes = map[pg_query.ObjectType]bool{
+	pg_query.ObjectType_OBJECT_TABLE:         true,
+	pg_query.ObjectType_OBJECT_VIEW:          true,
+	pg_query.ObjectType_OBJECT_MATVIEW:       true,
+	pg_query.ObjectType_OBJECT_FOREIGN_TABLE: true,
+	pg_query.ObjectType_OBJECT_SEQUENCE:      true,
+	pg_query.ObjectType_OBJECT_INDEX:         true,
+}
+
+// Relations parses q with the PostgreSQL parser and returns the relations
+// its statements reference, in order and without duplicates. A relation
+// both read and written is listed once for each. Names that refer to a
+// WITH query rather than a relation are left out.
+func Relations(q string) ([]Relation, error) {
+	tree, err := pg_query.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var relations []Relation
+	seen := map[Relation]bool{}
+	for _, raw := range tree.Stmts {
+		w := relationWalker{ctes: map[string]bool{}}
+		w.walk(raw.ProtoReflect(), false)
+		for _, r := range w.found {
+			if seen[r] || (r.Schema == "" && w.ctes[r.Name]) {
+				continue
+			}
+			seen[r] = true
+			relations = append(relations, r)
+		}
+	}
+	return relations, nil
+}
+
+// relationWalker collects the relations in the parse tree of a single
+// statement, along with the names of its WITH queries.
+type relationWalker struct {
+	found []Relation
+	ctes  map[string]bool
+}
+
+func access(write bool) string {
+	if write {
+		return AccessWrite
+	}
+	return AccessRead
+}
+
+func (w *relationWalker) walk(m protoreflect.Message, write bool) {
+	switch n := m.Interface().(type) {
+	case *pg_query.RangeVar:
+		w.found = append(w.found, Relation{Schema: n.Schemaname, Name: n.Relname, Access: access(write)})
+		return
+	case *pg_query.CommonTableExpr:
+		w.ctes[n.Ctename] = true
+	case *pg_query.DropStmt:
+		if dropRelationTypes[n.RemoveType] {
+			for _, obj := range n.Objects {
+				w.addName(obj.GetList().GetItems())
+			}
+			return
+		}
+	}
+
+	msgName := string(m.Descriptor().Name())
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() != protoreflect.MessageKind || fd.IsMap() {
+			return true
+		}
+		childWrite := write || writeFields[msgName+"."+string(fd.Name())]
+		if c, ok := m.Interface().(*pg_query.CopyStmt); ok && fd.Name() == "relation" {
+			// COPY FROM writes to the table, COPY TO reads it
+			childWrite = c.IsFrom
+		}
+		if fd.IsList() {
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				w.walk(list.Get(i).Message(), childWrite)
+			}
+		} else {
+			w.walk(v.Message(), childWrite)
+		}
+		return true
+	})
+}
+
+// addName adds the relation named by a dotted list of names, as found in
+// DROP statements, as written.
+func (w *relationWalker) addName(items []*pg_query.Node) {
+	var names []string
+	for _, item := range items {
+		names = append(names, item.GetString_().GetSval())
+	}
+	if len(names) == 0 {
+		return
+	}
+	r := Relation{Name: names[len(names)-1], Access: AccessWrite}
+	if len(names) > 1 {
+		r.Schema = names[len(names)-2]
+	}
+	w.found = append(w.found, r)
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"strings"
+)
+
+// Statement is a single SQL statement out of a (possibly multi-statement)
+// query string. Tokens excludes comments; those are kept in Comments.
+type Statement struct {
+	Text     string
+	Tokens   []Token
+	Comments []Token
+}
+
+// Split lexes a query string and splits it into statements on top-level
+// semicolons. Empty statements are dropped.
+func Split(s string) []Statement {
+	var stmts []Statement
+	cur := Statement{}
+	start := 0
+	depth := 0
+
+	flush := func(end int) {
+		if len(cur.Tokens) > 0 {
+			cur.Text = strings.TrimSpace(s[start:end])
+			stmts = append(stmts, cur)
+		}
+		cur = Statement{}
+	}
+
+	for _, t := range Lex(s) {
+		switch {
+		case t.Kind == Comment:
+			cur.Comments = append(cur.Comments, t)
+			continue
+		case t.IsPunct('(') || t.IsPunct('['):
+			depth++
+		case t.IsPunct(')') || t.IsPunct(']'):
+			if depth > 0 {
+				depth--
+			}
+		case t.IsPunct(';') && depth == 0:
+			flush(t.Pos)
+			start = t.Pos + 1
+			continue
+		}
+		if len(cur.Tokens) == 0 {
+			start = t.Pos
+		}
+		cur.Tokens = append(cur.Tokens, t)
+	}
+	flush(len(s))
+	return stmts
+}
+
+// TopLevel returns the tokens of the statement that are not nested inside
+// parentheses or brackets.
+func (s Statement) TopLevel() []Token {
+	var out []Token
+	depth := 0
+	for _, t := range s.Tokens {
+		switch {
+		case t.IsPunct('(') || t.IsPunct('['):
+			if depth == 0 {
+				out = append(out, t)
+			}
+			depth++
+			continue
+		case t.IsPunct(')') || t.IsPunct(']'):
+			if depth > 0 {
+				depth--
+			}
+		}
+		if depth == 0 {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Command returns the upper-cased keyword that determines what the
+// statement does. A leading WITH clause is skipped, so for
+// "WITH x AS (...) DELETE FROM t" the command is DELETE.
+func (s Statement) Command() string {
+	top := s.TopLevel()
+	if len(top) == 0 {
+		return ""
+	}
+	if !top[0].Is("WITH") {
+		return top[0].Upper()
+	}
+	for _, t := range top[1:] {
+		switch t.Upper() {
+		case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "VALUES", "TABLE":
+			if t.Kind == Ident {
+				return t.Upper()
+			}
+		}
+	}
+	return "WITH"
+}
+
+// HasTopLevel reports whether the statement contains the keyword kw
+// outside of any parentheses.
+func (s Statement) HasTopLevel(kw string) bool {
+	for _, t := range s.TopLevel() {
+		if t.Is(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasComment reports whether any comment of the query string contains
+// marker, e.g. "mammoth:confirm".
+func HasComment(s, marker string) bool {
+	for _, t := range Lex(s) {
+		if t.Kind == Comment && strings.Contains(t.Text, marker) {
+			return true
+		}
+	}
+	return false
+}
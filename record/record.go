@@ -0,0 +1,213 @@
+// Package record reads and writes session recordings: every message a
+// client sent to the backend during a session, with the time it was sent.
+//
+// A recording starts with a magic string and a varint-prefixed JSON
+// header. Each message follows as a record: the varint number of
+// microseconds since the previous record (or the start of the session),
+// a flags byte, the message type, and the varint-prefixed message body.
+// Times come from the monotonic clock, so they are immune to clock
+// adjustments during the session.
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/brunopadz/mammoth/protocol"
+)
+
+// Version of the recording format.
+const Version = 1
+
+// Ext is the extension of recording files.
+const Ext = ".mrec"
+
+const magic = "MAMMOTHREC"
+
+// Record flags.
+const (
+	// Mammoth didn't forward the message: it was rejected, or skipped
+	// after a rejection in the same batch
+	FlagRejected byte = 1 << iota
+)
+
+// Largest message body accepted when reading, the same as the backend's
+// own limit.
+const maxBodySize = 1 << 30
+
+// Header describes the recorded session.
+type Header struct {
+	Version  int       `json:"version"`
+	Session  string    `json:"session"`
+	Start    time.Time `json:"start"`
+	Client   string    `json:"client"`
+	User     string    `json:"user"`
+	Server   string    `json:"server"`
+	Database string    `json:"database"`
+	Target   string    `json:"target,omitempty"`
+}
+
+// Record is a recorded message.
+type Record struct {
+	// Time since the start of the session
+	Offset  time.Duration
+	Flags   byte
+	Message *protocol.Message
+}
+
+// Writer records the messages of a session to a file. It is not safe for
+// concurrent use.
+type Writer struct {
+	f     *os.File
+	w     *bufio.Writer
+	start time.Time
+	// Offset of the last record, in microseconds
+	last int64
+	buf  [binary.MaxVarintLen64]byte
+}
+
+// Create creates the recording file at path and writes its header. The
+// session starts now.
+func Create(path string, h Header) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	h.Version = Version
+	h.Start = start.UTC()
+	header, err := json.Marshal(h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := &Writer{f: f, w: bufio.NewWriter(f), start: start}
+	w.w.WriteString(magic)
+	w.writeUvarint(uint64(len(header)))
+	w.w.Write(header)
+	if err := w.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) writeUvarint(n uint64) {
+	l := binary.PutUvarint(w.buf[:], n)
+	w.w.Write(w.buf[:l])
+}
+
+// Write records m as sent now. Records are buffered; see Flush.
+func (w *Writer) Write(m *protocol.Message, flags byte) error {
+	offset := time.Since(w.start).Microseconds()
+	delta := offset - w.last
+	if delta < 0 {
+		delta = 0
+	}
+	w.last = offset
+
+	w.writeUvarint(uint64(delta))
+	w.w.WriteByte(flags)
+	w.w.WriteByte(m.Type)
+	w.writeUvarint(uint64(len(m.Body)))
+	_, err := w.w.Write(m.Body)
+	return err
+}
+
+// Flush writes buffered records to the file.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Reader reads a recording.
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	offset time.Duration
+}
+
+// NewReader reads the header of the recording in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil || string(m) != magic {
+		return nil, errors.New("Not a mammoth session recording")
+	}
+
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading recording header: %w", err)
+	}
+	if n > maxBodySize {
+		return nil, errors.New("Recording header too large")
+	}
+	header := make([]byte, n)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("Error reading recording header: %w", err)
+	}
+
+	rr := &Reader{r: br}
+	if err := json.Unmarshal(header, &rr.Header); err != nil {
+		return nil, fmt.Errorf("Error reading recording header: %w", err)
+	}
+	if rr.Header.Version != Version {
+		return nil, fmt.Errorf("Unsupported recording version %d", rr.Header.Version)
+	}
+	return rr, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the recording. A
+// recording cut short, e.g. by a crash, ends with io.ErrUnexpectedEOF.
+func (r *Reader) Next() (*Record, error) {
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	var head [2]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if n > maxBodySize {
+		return nil, errors.New("Recorded message too large")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return nil, unexpected(err)
+	}
+
+	r.offset += time.Duration(delta) * time.Microsecond
+	return &Record{
+		Offset:  r.offset,
+		Flags:   head[0],
+		Message: &protocol.Message{Type: head[1], Body: body},
+	}, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/stats"
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// How long in-flight admin requests may take to finish on shutdown.
+const adminShutdownTimeout = 5 * time.Second
+
+// AdminServer serves the admin HTTP interface:
+//
+//	GET /stats/statements     statement statistics, see below
+//	DELETE /stats/statements  resets the statement statistics
+//
+// Statistics can be filtered with the user and target query parameters,
+// sorted with sort (total, mean, max, calls, errors or rows) and cut down
+// with limit.
+type AdminServer struct {
+	c     config.Admin
+	stats *stats.Collector
+	srv   *http.Server
+}
+
+func NewAdminServer(c config.Admin, st *stats.Collector) *AdminServer {
+	s := &AdminServer{c: c, stats: st}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats/statements", s.handleStatements)
+	s.srv = &http.Server{
+		Handler:           s.authorize(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *AdminServer) Serve(l net.Listener) {
+	log.Infof("Admin Server listening on: %s", l.Addr())
+	if err := s.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		log.Errorf("Admin Server error: %v", err)
+	}
+}
+
+func (s *AdminServer) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+	defer cancel()
+	s.srv.Shutdown(ctx)
+}
+
+// authorize requires the configured token, if any, as a bearer token.
+func (s *AdminServer) authorize(h http.Handler) http.Handler {
+	if s.c.Token == "" {
+		return h
+	}
+	want := []byte("Bearer " + s.c.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statsOrder compares statistics for each sort parameter, largest first.
+var statsOrder = map[string]func(a, b *audit.StatementStats) bool{
+	"total":  func(a, b *audit.StatementStats) bool { return a.TotalTimeMs > b.TotalTimeMs },
+	"mean":   func(a, b *audit.StatementStats) bool { return a.MeanTimeMs > b.MeanTimeMs },
+	"max":    func(a, b *audit.StatementStats) bool { return a.MaxTimeMs > b.MaxTimeMs },
+	"calls":  func(a, b *audit.StatementStats) bool { return a.Calls > b.Calls },
+	"errors": func(a, b *audit.StatementStats) bool { return a.Errors > b.Errors },
+	"rows":   func(a, b *audit.StatementStats) bool { return a.Rows > b.Rows },
+}
+
+func (s *AdminServer) handleStatements(w http.ResponseWriter, r *http.Request) {
+	if s.stats == nil {
+		http.Error(w, "Statement statistics are disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		s.stats.Reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = "total"
+	}
+	less, ok := statsOrder[sortBy]
+	if !ok {
+		http.Error(w, "Unknown sort order: "+sortBy, http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit: "+l, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	list := []audit.StatementStats{}
+	for _, st := range s.stats.Totals() {
+		if (q.Has("user") && st.User != q.Get("user")) || (q.Has("target") && st.Target != q.Get("target")) {
+			continue
+		}
+		list = append(list, st)
+	}
+	// Totals come sorted by total time, which breaks ties
+	sort.SliceStable(list, func(i, j int) bool { return less(&list[i], &list[j]) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Debugf("Error writing admin response: %v", err)
+	}
+}
@@ -17,21 +17,26 @@ package server
 import (
 	"net"
 
+	"github.com/Sirupsen/logrus"
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/config"
 	"github.com/brunopadz/mammoth/proxy"
+	"github.com/brunopadz/mammoth/stats"
 	"github.com/brunopadz/mammoth/util/log"
 )
 
 type ProxyServer struct {
+	c        *config.Config
 	ch       chan bool
 	p        *proxy.Proxy
 	listener net.Listener
 }
 
-func NewProxyServer(c *config.Config) *ProxyServer {
+func NewProxyServer(c *config.Config, a *audit.Auditor, st *stats.Collector) *ProxyServer {
 	p := &ProxyServer{
+		c:  c,
 		ch: make(chan bool),
-		p:  proxy.NewProxy(c),
+		p:  proxy.NewProxy(c, a, st),
 	}
 
 	return p
@@ -54,10 +59,37 @@ func (s *ProxyServer) Serve(l net.Listener) {
 			continue
 		}
 
-		go s.p.HandleConnection(conn)
+		go s.admit(conn)
 	}
 }
 
+// admit resolves the real client address of a freshly accepted connection
+// and checks it against the global access list before handing it over to
+// the proxy.
+func (s *ProxyServer) admit(conn net.Conn) {
+	if s.c.IsTrustedProxy(config.AddrIP(conn.RemoteAddr())) {
+		proxied, err := readProxyHeader(conn)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"client": conn.RemoteAddr().String(),
+			}).Infof("Error reading PROXY protocol header: %v", err)
+			conn.Close()
+			return
+		}
+		conn = proxied
+	}
+
+	if !s.c.Access.Permits(config.AddrIP(conn.RemoteAddr())) {
+		log.WithFields(logrus.Fields{
+			"client": conn.RemoteAddr().String(),
+		}).Info("Rejecting connection from disallowed address")
+		conn.Close()
+		return
+	}
+
+	s.p.HandleConnection(conn)
+}
+
 func (s *ProxyServer) Stop() {
 	s.listener.Close()
 	close(s.ch)
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a trusted proxy has to send its PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader = errors.New("Malformed PROXY protocol header")
+)
+
+// proxiedConn is a connection whose remote address was taken from a PROXY
+// protocol header. Bytes peeked while looking for the header are replayed
+// from the buffered reader.
+type proxiedConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// readProxyHeader consumes a PROXY protocol (v1 or v2) header, if one is
+// present, and returns a connection reporting the client address it carried.
+// A connection without a header is returned with its own address.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+	pc := &proxiedConn{Conn: conn, r: r, remoteAddr: conn.RemoteAddr()}
+
+	// A startup packet is at least 8 bytes long, so peeking the shorter
+	// v1 prefix never blocks on a connection without a header.
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		addr, err := readProxyV1(r)
+		if err != nil {
+			return nil, err
+		}
+		if addr != nil {
+			pc.remoteAddr = addr
+		}
+		return pc, nil
+	}
+
+	if prefix[0] == proxyV2Sig[0] {
+		sig, err := r.Peek(len(proxyV2Sig))
+		if err == nil && bytes.Equal(sig, proxyV2Sig) {
+			addr, err := readProxyV2(r)
+			if err != nil {
+				return nil, err
+			}
+			if addr != nil {
+				pc.remoteAddr = addr
+			}
+			return pc, nil
+		}
+	}
+
+	return pc, nil
+}
+
+// readProxyV1 parses a human-readable header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The specification caps the header at 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+
+	parts := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, errProxyHeader
+	}
+	port, err := strconv.Atoi(parts[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 parses the binary header format.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	verCmd := hdr[12]
+	family := hdr[13]
+	length := binary.BigEndian.Uint16(hdr[14:16])
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("Unsupported PROXY protocol version %d", verCmd>>4)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections (health checks from the proxy itself) keep the
+	// address of the proxy
+	if verCmd&0x0f == 0x0 {
+		return nil, nil
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brunopadz/mammoth/config"
+)
+
+// testConn plays back the bytes a peer sent.
+type testConn struct {
+	net.Conn
+	r      io.Reader
+	addr   net.Addr
+	closed bool
+}
+
+func newTestConn(addr string, data []byte) *testConn {
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	return &testConn{r: bytes.NewReader(data), addr: tcpAddr}
+}
+
+func (c *testConn) Read(b []byte) (int, error)        { return c.r.Read(b) }
+func (c *testConn) RemoteAddr() net.Addr              { return c.addr }
+func (c *testConn) SetReadDeadline(t time.Time) error { return nil }
+func (c *testConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// proxyV2 builds a v2 header with the given version and command byte,
+// address family and address block.
+func proxyV2(verCmd, family byte, addrs []byte) []byte {
+	b := append([]byte{}, proxyV2Sig...)
+	b = append(b, verCmd, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+// proxyV2Addrs builds the address block of a TCP header from source to
+// 198.51.100.1:5432, padded with TLVs.
+func proxyV2Addrs(src net.IP, port uint16, tlvs int) []byte {
+	dst := net.ParseIP("198.51.100.1").To4()
+	if src.To4() == nil {
+		dst = net.ParseIP("2001:db8::1")
+	}
+	b := append(append([]byte{}, src...), dst...)
+	b = binary.BigEndian.AppendUint16(b, port)
+	b = binary.BigEndian.AppendUint16(b, 5432)
+	return append(b, make([]byte, tlvs)...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	const proxyAddr = "10.0.0.1:40000"
+	startup := []byte("\x00\x00\x00\x08\x04\xd2\x16\x2f")
+	v4 := net.ParseIP("192.0.2.1").To4()
+	v6 := net.ParseIP("2001:db8::7")
+
+	tests := []struct {
+		name   string
+		header []byte
+		// Empty if reading the header must fail
+		addr string
+	}{
+		{"no header", nil, proxyAddr},
+		{"v2 signature prefix only", []byte("\r\n\r\nxyz"), proxyAddr},
+
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n"), "192.0.2.1:56324"},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 56324 5432\r\n"), "[2001:db8::7]:56324"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), proxyAddr},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN 192.0.2.1 198.51.100.1 56324 5432\r\n"), proxyAddr},
+		{"v1 without CR", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\n"), ""},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1 198.51"), ""},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), ""},
+		{"v1 missing field", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"), ""},
+		{"v1 extra field", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432 x\r\n"), ""},
+		{"v1 double space", []byte("PROXY TCP4  192.0.2.1 198.51.100.1 56324 5432\r\n"), ""},
+		{"v1 unknown protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 5432\r\n"), ""},
+		{"v1 invalid address", []byte("PROXY TCP4 192.0.2.256 198.51.100.1 56324 5432\r\n"), ""},
+		{"v1 host name", []byte("PROXY TCP4 client.example 198.51.100.1 56324 5432\r\n"), ""},
+		{"v1 port out of range", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 5432\r\n"), ""},
+		{"v1 negative port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 -1 5432\r\n"), ""},
+		{"v1 invalid port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 postgres 5432\r\n"), ""},
+
+		{"v2 TCP4", proxyV2(0x21, 0x11, proxyV2Addrs(v4, 56324, 0)), "192.0.2.1:56324"},
+		{"v2 TCP4 with TLVs", proxyV2(0x21, 0x11, proxyV2Addrs(v4, 56324, 8)), "192.0.2.1:56324"},
+		{"v2 TCP6", proxyV2(0x21, 0x21, proxyV2Addrs(v6, 56324, 0)), "[2001:db8::7]:56324"},
+		{"v2 LOCAL", proxyV2(0x20, 0x11, proxyV2Addrs(v4, 56324, 0)), proxyAddr},
+		{"v2 LOCAL without addresses", proxyV2(0x20, 0x00, nil), proxyAddr},
+		{"v2 unspecified family", proxyV2(0x21, 0x00, nil), proxyAddr},
+		{"v2 UNIX socket", proxyV2(0x21, 0x31, make([]byte, 216)), proxyAddr},
+		{"v2 version 1", proxyV2(0x11, 0x11, proxyV2Addrs(v4, 56324, 0)), ""},
+		{"v2 version 3", proxyV2(0x31, 0x11, proxyV2Addrs(v4, 56324, 0)), ""},
+		{"v2 truncated header", proxyV2(0x21, 0x11, nil)[:14], ""},
+		{"v2 truncated addresses", proxyV2(0x21, 0x11, proxyV2Addrs(v4, 56324, 0))[:20], ""},
+		{"v2 TCP4 addresses too short", proxyV2(0x21, 0x11, make([]byte, 8)), ""},
+		{"v2 TCP6 addresses too short", proxyV2(0x21, 0x21, proxyV2Addrs(v4, 56324, 0)), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.addr == "" {
+				// The peer hangs up after the header
+				conn, err := readProxyHeader(newTestConn(proxyAddr, test.header))
+				if err == nil {
+					t.Fatalf("got client address %v, want an error", conn.RemoteAddr())
+				}
+				return
+			}
+			data := append(append([]byte{}, test.header...), startup...)
+			conn, err := readProxyHeader(newTestConn(proxyAddr, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conn.RemoteAddr().String(); got != test.addr {
+				t.Errorf("got client address %v, want %v", got, test.addr)
+			}
+
+			// Whatever follows the header is left to the proxy
+			want := startup
+			if test.addr == proxyAddr && !bytes.HasPrefix(test.header, proxyV1Prefix) && !bytes.HasPrefix(test.header, proxyV2Sig) {
+				want = data
+			}
+			if rest, _ := io.ReadAll(conn); !bytes.Equal(rest, want) {
+				t.Errorf("read %q after the header, want %q", rest, want)
+			}
+		})
+	}
+}
+
+// Only the first header is taken: a client behind the proxy can't pick its
+// address by sending a header of its own.
+func TestReadProxyHeaderOnce(t *testing.T) {
+	spoofed := []byte("PROXY TCP4 192.0.2.99 198.51.100.1 1 5432\r\n")
+	data := append([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n"), spoofed...)
+
+	conn, err := readProxyHeader(newTestConn("10.0.0.1:40000", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("got client address %v, want 192.0.2.1:56324", got)
+	}
+	if rest, _ := io.ReadAll(conn); !bytes.Equal(rest, spoofed) {
+		t.Errorf("read %q after the header, want the client's own header", rest)
+	}
+}
+
+// admit only honours headers from trusted proxies, and checks the address
+// they carry against the access list.
+func TestAdmitProxyHeader(t *testing.T) {
+	trusted := []*net.IPNet{{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}}
+	allowed := config.AccessList{Allow: []*net.IPNet{{IP: net.ParseIP("192.0.2.1"), Mask: net.CIDRMask(32, 32)}}}
+	header := []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 5432\r\n")
+	denied := []byte("PROXY TCP4 192.0.2.2 198.51.100.1 56324 5432\r\n")
+
+	tests := []struct {
+		name string
+		peer string
+		data []byte
+	}{
+		{"header from an untrusted peer", "198.51.100.7:40000", header},
+		{"trusted proxy relaying a denied client", "10.0.0.1:40000", denied},
+		{"malformed header from a trusted proxy", "10.0.0.1:40000", []byte("PROXY TCP4 192.0.2.1\r\n")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Without a proxy behind it, admitting the connection panics
+			s := &ProxyServer{c: &config.Config{TrustedProxies: trusted, Access: allowed}}
+			conn := newTestConn(test.peer, test.data)
+			s.admit(conn)
+			if !conn.closed {
+				t.Error("connection was not closed")
+			}
+		})
+	}
+}
@@ -17,19 +17,28 @@ package server
 import (
 	"net"
 
+	"github.com/brunopadz/mammoth/audit"
 	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/stats"
 	"github.com/brunopadz/mammoth/util/log"
 )
 
 type Server struct {
 	c     *config.Config
 	proxy *ProxyServer
+	admin *AdminServer
+	stats *stats.Collector
 }
 
-func NewServer(c *config.Config) *Server {
+func NewServer(c *config.Config, a *audit.Auditor) *Server {
+	st := stats.NewCollector(c.Stats, a)
 	s := &Server{
 		c:     c,
-		proxy: NewProxyServer(c),
+		proxy: NewProxyServer(c, a, st),
+		stats: st,
+	}
+	if c.Admin.Bind != "" {
+		s.admin = NewAdminServer(c.Admin, st)
 	}
 	return s
 }
@@ -42,8 +51,23 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	if s.admin != nil {
+		adminListener, err := net.Listen("tcp", s.c.Admin.Bind)
+		if err != nil {
+			log.Fatalf("Could not create admin listener on %v: %v\n", s.c.Admin.Bind, err)
+			return err
+		}
+		go s.admin.Serve(adminListener)
+	}
+
 	s.proxy.Serve(proxyListener)
 
+	if s.admin != nil {
+		s.admin.Stop()
+	}
+	if s.stats != nil {
+		s.stats.Close()
+	}
 	log.Info("Server exiting...")
 	return nil
 }
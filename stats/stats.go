@@ -0,0 +1,202 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/brunopadz/mammoth/audit"
+	"github.com/brunopadz/mammoth/config"
+	"github.com/brunopadz/mammoth/query"
+	"github.com/brunopadz/mammoth/util/log"
+)
+
+// Execution is a statement execution that has completed.
+type Execution struct {
+	// See query.Fingerprint
+	Fingerprint string
+	Query       string
+	User        string
+	Target      string
+	Duration    time.Duration
+	Rows        int64
+	Failed      bool
+}
+
+type key struct {
+	fingerprint string
+	user        string
+	target      string
+}
+
+// table holds the statistics of each key, up to a maximum number of keys.
+type table map[key]*audit.StatementStats
+
+func (t table) add(e *Execution, max int, now time.Time) {
+	k := key{e.Fingerprint, e.User, e.Target}
+	st := t[k]
+	if st == nil {
+		if len(t) >= max {
+			t.evict()
+		}
+		st = &audit.StatementStats{
+			Fingerprint: e.Fingerprint,
+			Query:       query.Normalize(e.Query),
+			User:        e.User,
+			Target:      e.Target,
+			Since:       now,
+		}
+		t[k] = st
+	}
+
+	ms := float64(e.Duration) / float64(time.Millisecond)
+	if st.Calls == 0 || ms < st.MinTimeMs {
+		st.MinTimeMs = ms
+	}
+	if ms > st.MaxTimeMs {
+		st.MaxTimeMs = ms
+	}
+	st.Calls++
+	if e.Failed {
+		st.Errors++
+	}
+	st.Rows += e.Rows
+	st.TotalTimeMs += ms
+	st.MeanTimeMs = st.TotalTimeMs / float64(st.Calls)
+	st.LastCall = now
+}
+
+// evict drops the least-called entry, the least recently called among
+// equals, like pg_stat_statements does.
+func (t table) evict() {
+	var victim key
+	var min *audit.StatementStats
+	for k, st := range t {
+		if min == nil || st.Calls < min.Calls || (st.Calls == min.Calls && st.LastCall.Before(min.LastCall)) {
+			victim, min = k, st
+		}
+	}
+	delete(t, victim)
+}
+
+// Collector aggregates statement executions by fingerprint, user and
+// target. It keeps totals, which can be read and reset at any time, and
+// the statistics of the current summary interval, which are audited as
+// stats.summary events once the interval is over. It is safe for concurrent
+// use.
+type Collector struct {
+	c       config.Stats
+	auditor *audit.Auditor
+
+	mtx         sync.Mutex
+	total       table
+	period      table
+	periodStart time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCollector starts a collector, or returns nil if statistics are
+// disabled.
+func NewCollector(c config.Stats, a *audit.Auditor) *Collector {
+	if !c.Enabled {
+		return nil
+	}
+	s := &Collector{
+		c:       c,
+		auditor: a,
+		total:   table{},
+		period:  table{},
+		// Summaries cover whole intervals, calls or not
+		periodStart: time.Now().UTC(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Record adds a completed execution to the statistics.
+func (s *Collector) Record(e *Execution) {
+	now := time.Now().UTC()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.total.add(e, s.c.MaxEntries, now)
+	s.period.add(e, s.c.MaxEntries, now)
+}
+
+// Totals returns the statistics since startup or the last reset, most
+// time-consuming first.
+func (s *Collector) Totals() []audit.StatementStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return sorted(s.total)
+}
+
+// Reset discards the totals. The current summary interval is unaffected.
+func (s *Collector) Reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.total = table{}
+}
+
+func sorted(t table) []audit.StatementStats {
+	list := make([]audit.StatementStats, 0, len(t))
+	for _, st := range t {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].TotalTimeMs != list[j].TotalTimeMs {
+			return list[i].TotalTimeMs > list[j].TotalTimeMs
+		}
+		return list[i].Fingerprint < list[j].Fingerprint
+	})
+	return list
+}
+
+func (s *Collector) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.c.SummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.summarize()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// summarize audits the statistics of the interval that just ended, one
+// event per entry, and starts a new interval.
+func (s *Collector) summarize() {
+	now := time.Now().UTC()
+	s.mtx.Lock()
+	period, start := s.period, s.periodStart
+	s.period, s.periodStart = table{}, now
+	s.mtx.Unlock()
+
+	for _, st := range sorted(period) {
+		st := st
+		st.Since = start
+		err := s.auditor.Emit(&audit.Event{
+			Time:  now,
+			Type:  audit.EventStatsSummary,
+			Stats: &st,
+		})
+		if err != nil {
+			log.Errorf("Unable to write audit event: %v", err)
+		}
+	}
+}
+
+// Close audits the statistics of the last, partial interval. It must be
+// called before the auditor is closed.
+func (s *Collector) Close() {
+	close(s.stop)
+	<-s.done
+	s.summarize()
+}
@@ -32,7 +32,8 @@ func init() {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
-	logrus.SetOutput(os.Stdout)
+	// Keep stdout for audit events
+	logrus.SetOutput(os.Stderr)
 }
 
 func Debug(msg string) {